@@ -2,7 +2,11 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/Eldiai/go_library/internal/data"
 	"github.com/Eldiai/go_library/internal/validator"
@@ -15,6 +19,7 @@ func (app *application) createBook(w http.ResponseWriter, r *http.Request) {
 		Year       int32    `json:"year"`
 		Genres     []string `json:"genres"`
 		ReleasedAt int32    `json:"released_at"`
+		Language   string   `json:"language"`
 	}
 
 	if err := app.readJSON(w, r, &input); err != nil {
@@ -28,6 +33,10 @@ func (app *application) createBook(w http.ResponseWriter, r *http.Request) {
 		Year:       input.Year,
 		Genres:     input.Genres,
 		ReleasedAt: input.ReleasedAt,
+		Language:   input.Language,
+	}
+	if book.Language == "" {
+		book.Language = data.DefaultBookLanguage
 	}
 
 	v := validator.New()
@@ -42,7 +51,10 @@ func (app *application) createBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := app.writeJSON(w, http.StatusCreated, envelope{"book": book}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(book.Version))
+
+	err := app.writeJSON(w, http.StatusCreated, envelope{"book": book}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -66,7 +78,25 @@ func (app *application) listBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	availability, err := app.models.Copies.GetAvailability(book.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	etag := bookETag(book, availability)
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", cacheControl(app.booksMaxAge))
+	headers.Set("ETag", etag)
+	headers.Set("Last-Modified", book.UpdatedAt.UTC().Format(http.TimeFormat))
+
+	if notModified(r, etag, book.UpdatedAt) {
+		app.writeNotModified(w, headers)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book, "availability": availability}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -74,37 +104,74 @@ func (app *application) listBook(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) listBooks(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Author string
-		Genres []string
+		data.BookSearch
 		data.Filters
+		Facets []string
 	}
 	v := validator.New()
 
 	qs := r.URL.Query()
 
-	input.Title = app.readString(qs, "title", "")
-	input.Title = app.readString(qs, "author", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.BookSearch = app.readBookSearch(qs, v)
+	input.Facets = app.readCSV(qs, "facets", []string{})
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
 
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "-id", "-title", "-year"}
+	input.Filters.Sort = app.readString(qs, "sort", defaultBookSort(input.BookSearch))
+	input.Filters.SortSafelist = bookSortSafelist
 
+	for _, facet := range input.Facets {
+		v.Check(validator.In(facet, data.BookFacets...), "facets", "must be a list of genres, author, year or availability")
+	}
+	checkBookSort(v, input.BookSearch, input.Filters)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	books, metadata, err := app.models.Books.GetAll(input.Title, input.Author, input.Genres, input.Filters)
+	books, metadata, err := app.models.Books.GetAll(input.BookSearch, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidCursor):
+			v.AddError("cursor", "must be a cursor for the same sort from a previous page")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(input.Facets) > 0 {
+		metadata.Facets, err = app.models.Books.GetFacets(input.BookSearch, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"books": books, "metadata": metadata}
+
+	// Lists are only validated by their content: removing a book doesn't leave a timestamp
+	// behind, so Last-Modified can't be relied on here.
+	etag, err := jsonETag(env)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"books": books, "metadata": metadata}, nil)
+	headers := make(http.Header)
+	headers.Set("Cache-Control", cacheControl(app.booksMaxAge))
+	headers.Set("ETag", etag)
+
+	if notModified(r, etag, time.Time{}) {
+		app.writeNotModified(w, headers)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -130,12 +197,18 @@ func (app *application) updateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !ifMatchVersion(r, book.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Title      *string  `json:"title"`
 		Author     *string  `json:"author"`
 		Year       *int32   `json:"year"`
 		Genres     []string `json:"genres"`
 		ReleasedAt *int32   `json:"released_at"`
+		Language   *string  `json:"language"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -146,6 +219,9 @@ func (app *application) updateBook(w http.ResponseWriter, r *http.Request) {
 	if input.Title != nil {
 		book.Title = *input.Title
 	}
+	if input.Author != nil {
+		book.Author = *input.Author
+	}
 
 	if input.Year != nil {
 		book.Year = *input.Year
@@ -156,6 +232,9 @@ func (app *application) updateBook(w http.ResponseWriter, r *http.Request) {
 	if input.Genres != nil {
 		book.Genres = input.Genres
 	}
+	if input.Language != nil {
+		book.Language = *input.Language
+	}
 
 	v := validator.New()
 	if data.ValidateBook(v, book); !v.Valid() {
@@ -166,6 +245,8 @@ func (app *application) updateBook(w http.ResponseWriter, r *http.Request) {
 	err = app.models.Books.Update(book)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -174,7 +255,10 @@ func (app *application) updateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(book.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -187,11 +271,33 @@ func (app *application) deleteBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.models.Books.Delete(id)
+	// With an If-Match header, only delete the book if it hasn't changed.
+	var version int32
+	if r.Header.Get("If-Match") != "" {
+		book, err := app.models.Books.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if !ifMatchVersion(r, book.Version) {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+		version = book.Version
+	}
+
+	err = app.models.Books.Delete(id, version)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -202,3 +308,106 @@ func (app *application) deleteBook(w http.ResponseWriter, r *http.Request) {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// bookETag identifies what GET /v1/books/:id returns: the version of the book, and the
+// availability of its copies.
+func bookETag(book *data.Book, availability data.Availability) string {
+	return fmt.Sprintf(`"%d.%d.%d"`, book.Version, availability.Available, availability.Total)
+}
+
+// bookSortSafelist holds the sort values accepted when listing and exporting books.
+// "-relevance" puts the best matches for q first.
+var bookSortSafelist = []string{"id", "title", "year", "relevance", "-id", "-title", "-year", "-relevance"}
+
+// readBookSearch reads the query string parameters shared by listBooks and exportBooks.
+// language picks the text search configuration q is parsed with; the default, simple, matches
+// words as they are written in books of any language. fuzzy=true makes title and author
+// tolerate typos, as long as they are at least similarity (from 0 to 1) alike. genres keeps
+// books with all the listed genres and genres_any those with any of them; authors can be
+// repeated to keep books by any of several authors. The year_min, year_max, released_min and
+// released_max ranges include both ends, and created_after takes an RFC 3339 timestamp.
+func (app *application) readBookSearch(qs url.Values, v *validator.Validator) data.BookSearch {
+	branchID := app.readInt(qs, "branch", 0, v)
+	v.Check(branchID >= 0, "branch", "must be a valid branch id")
+
+	fuzzy := app.readString(qs, "fuzzy", "false")
+	v.Check(validator.In(fuzzy, "true", "false"), "fuzzy", "must be true or false")
+
+	search := data.BookSearch{
+		Title:       app.readString(qs, "title", ""),
+		Author:      app.readString(qs, "author", ""),
+		Authors:     qs["authors"],
+		Genres:      app.readCSV(qs, "genres", []string{}),
+		GenresAny:   app.readCSV(qs, "genres_any", []string{}),
+		YearMin:     app.readInt32(qs, "year_min", 0, v),
+		YearMax:     app.readInt32(qs, "year_max", 0, v),
+		ReleasedMin: app.readInt32(qs, "released_min", 0, v),
+		ReleasedMax: app.readInt32(qs, "released_max", 0, v),
+		BranchID:    int64(branchID),
+		Query:       app.readString(qs, "q", ""),
+		Language:    app.readString(qs, "language", data.DefaultBookLanguage),
+		Fuzzy:       fuzzy == "true",
+		Similarity:  app.readFloat(qs, "similarity", data.DefaultBookSimilarity, v),
+	}
+
+	if createdAfter := qs.Get("created_after"); createdAfter != "" {
+		t, err := time.Parse(time.RFC3339, createdAfter)
+		if err != nil {
+			v.AddError("created_after", "must be an RFC 3339 timestamp")
+		}
+		search.CreatedAfter = t
+	}
+
+	v.Check(search.YearMax == 0 || search.YearMin <= search.YearMax, "year_min", "must not be greater than year_max")
+	v.Check(search.ReleasedMax == 0 || search.ReleasedMin <= search.ReleasedMax, "released_min", "must not be greater than released_max")
+	v.Check(len(search.Authors) <= 50, "authors", "must not have more than 50 values")
+	v.Check(len(search.Query) <= 500, "q", "must not be more than 500 bytes long")
+	v.Check(validator.In(search.Language, data.BookLanguages...), "language", "must be a supported language")
+	v.Check(search.Similarity > 0 && search.Similarity <= 1, "similarity", "must be greater than 0 and at most 1")
+
+	return search
+}
+
+// defaultBookSort orders books by relevance when the search ranks them, and by id otherwise.
+func defaultBookSort(search data.BookSearch) string {
+	if search.Ranked() {
+		return "-relevance"
+	}
+	return "id"
+}
+
+func checkBookSort(v *validator.Validator, search data.BookSearch, filters data.Filters) {
+	sortsByRelevance := strings.TrimPrefix(filters.Sort, "-") == "relevance"
+	v.Check(!sortsByRelevance || search.Ranked(), "sort", "relevance needs a q, or a fuzzy title or author, to rank by")
+}
+
+// suggestBooks offers titles and authors with a word starting with prefix, for
+// autocompleting searches.
+func (app *application) suggestBooks(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	prefix := strings.TrimSpace(app.readString(qs, "prefix", ""))
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(prefix != "", "prefix", "must be provided")
+	v.Check(len(prefix) <= 100, "prefix", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 25, "limit", "must be a maximum of 25")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Books.Suggest(prefix, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
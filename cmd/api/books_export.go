@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/validator"
+)
+
+const (
+	// maxConcurrentExports is how many exports can run at once. Each holds a transaction and a
+	// cursor open for as long as its client takes to read the file.
+	maxConcurrentExports = 4
+	// exportWriteTimeout is how long a client has to take each part of an export. It is
+	// extended before every write, so a large export can run for as long as the client keeps
+	// reading, but a stalled one is cut off.
+	exportWriteTimeout = 30 * time.Second
+)
+
+// exportFormats maps each export format to its Content-Type and file extension.
+var exportFormats = map[string]struct{ contentType, extension string }{
+	"csv":    {"text/csv; charset=utf-8", "csv"},
+	"ndjson": {"application/x-ndjson", "ndjson"},
+	"marc":   {"text/plain; charset=utf-8", "mrk"},
+}
+
+// exportBooks streams every book matching the listBooks filters as a file download. CSV files
+// use the columns importBooks reads, plus the id, so they can be imported again; marc writes
+// MARC 21 records in the mnemonic (.mrk) text form. Books are read from the database as they
+// are written out, so exports of any size run in constant memory. Only maxConcurrentExports
+// run at once, and the rest are turned away until one finishes.
+func (app *application) exportBooks(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.BookSearch
+		data.Filters
+	}
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", "csv")
+	input.BookSearch = app.readBookSearch(qs, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", defaultBookSort(input.BookSearch))
+	input.Filters.SortSafelist = bookSortSafelist
+
+	_, ok := exportFormats[format]
+	v.Check(ok, "format", "must be csv, ndjson or marc")
+	v.Check(validator.In(input.Filters.Sort, input.Filters.SortSafelist...), "sort", "invalid sort value")
+	checkBookSort(v, input.BookSearch, input.Filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	select {
+	case app.exports <- struct{}{}:
+		defer func() { <-app.exports }()
+	default:
+		app.serverBusyResponse(w, r, exportWriteTimeout)
+		return
+	}
+
+	// Large exports take longer than the server's write timeout allows for, so the deadline is
+	// replaced by one which rolls forward with each write.
+	out := &exportWriter{w: w, rc: http.NewResponseController(w)}
+	if err := out.extendDeadline(); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var enc bookEncoder
+	buf := bufio.NewWriterSize(out, 32*1024)
+	switch format {
+	case "csv":
+		enc = newCSVBookEncoder(buf)
+	case "ndjson":
+		enc = ndjsonBookEncoder{json.NewEncoder(buf)}
+	case "marc":
+		enc = marcBookEncoder{buf}
+	}
+
+	headers := w.Header()
+	headers.Set("Content-Type", exportFormats[format].contentType)
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="books-%s.%s"`, time.Now().UTC().Format("20060102"), exportFormats[format].extension))
+
+	err := app.models.Books.Export(r.Context(), input.BookSearch, input.Filters, enc.Encode)
+	if err == nil {
+		err = enc.Flush()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		// Once part of the file is sent the status can't change, so all that's left is to log
+		// the error and cut the download short.
+		if !out.started {
+			headers.Del("Content-Disposition")
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logError(r, err)
+	}
+}
+
+// exportWriter passes writes through to w, recording whether any have been made and giving
+// each of them exportWriteTimeout to complete.
+type exportWriter struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	started bool
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	if err := e.extendDeadline(); err != nil {
+		return 0, err
+	}
+	e.started = true
+	return e.w.Write(p)
+}
+
+// extendDeadline moves the write deadline to exportWriteTimeout from now. Writers which don't
+// support deadlines, such as those in tests, are left to the server's timeouts.
+func (e *exportWriter) extendDeadline() error {
+	err := e.rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+// bookEncoder writes books in one of the export formats.
+type bookEncoder interface {
+	Encode(book *data.Book) error
+	Flush() error
+}
+
+type csvBookEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVBookEncoder(w io.Writer) *csvBookEncoder {
+	return &csvBookEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *csvBookEncoder) Encode(book *data.Book) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	return e.w.Write([]string{
+		strconv.FormatInt(book.ID, 10),
+		book.Title,
+		book.Author,
+		formatOptionalInt(book.Year),
+		strings.Join(book.Genres, "|"),
+		formatOptionalInt(book.ReleasedAt),
+		book.Language,
+	})
+}
+
+// Flush writes the header if no books were exported, so that an empty export is still a
+// valid CSV file.
+func (e *csvBookEncoder) Flush() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvBookEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	header := append([]string{"id"}, importColumns...)
+	return e.w.Write(append(header, "language"))
+}
+
+func formatOptionalInt(n int32) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64(n), 10)
+}
+
+type ndjsonBookEncoder struct {
+	enc *json.Encoder
+}
+
+func (e ndjsonBookEncoder) Encode(book *data.Book) error {
+	return e.enc.Encode(book)
+}
+
+func (e ndjsonBookEncoder) Flush() error {
+	return nil
+}
+
+// marcBookEncoder writes MARC 21 bibliographic records in the mnemonic form used by MarcEdit,
+// one field per line and a blank line after each record. The leader's lengths are left as
+// zeros, which the tools compiling .mrk files fill in.
+type marcBookEncoder struct {
+	w io.Writer
+}
+
+var marcEscaper = strings.NewReplacer("$", "{dollar}", "\n", " ", "\r", " ")
+
+func (e marcBookEncoder) Encode(book *data.Book) error {
+	var b strings.Builder
+
+	b.WriteString("=LDR  00000nam a2200000 a 4500\n")
+	fmt.Fprintf(&b, "=001  %d\n", book.ID)
+	if book.Author != "" {
+		fmt.Fprintf(&b, "=100  1\\$a%s\n", marcEscaper.Replace(book.Author))
+	}
+	fmt.Fprintf(&b, "=245  00$a%s\n", marcEscaper.Replace(book.Title))
+	if book.Year != 0 {
+		fmt.Fprintf(&b, "=260  \\\\$c%d\n", book.Year)
+	}
+	for _, genre := range book.Genres {
+		fmt.Fprintf(&b, "=650  \\4$a%s\n", marcEscaper.Replace(genre))
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e marcBookEncoder) Flush() error {
+	return nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/validator"
+)
+
+const (
+	// maxImportBytes limits the size of an import, which is read into memory before any of it
+	// is saved.
+	maxImportBytes = 10 << 20
+	// importBatchSize is how many books are inserted per transaction.
+	importBatchSize = 500
+)
+
+// importRow is the outcome for one record of an import. Row is the line number in the file.
+type importRow struct {
+	Row    int               `json:"row"`
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+
+	book *data.Book
+}
+
+const (
+	importCreated  = "created"
+	importValid    = "valid"
+	importRejected = "rejected"
+)
+
+// importBooks creates books from a CSV or NDJSON upload. CSV files need a header row naming
+// the title, author, year, genres and released_at columns, with genres separated by "|", and
+// may add a language column.
+// Every record is validated and reported on, and the valid ones are inserted in batches;
+// with dry_run=true nothing is saved.
+func (app *application) importBooks(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", "")
+	dryRun := app.readString(qs, "dry_run", "false")
+
+	if format == "" {
+		format = importFormat(r.Header.Get("Content-Type"))
+	}
+	v.Check(validator.In(format, "csv", "ndjson"), "format", "must be csv or ndjson, or given by a text/csv or application/x-ndjson Content-Type")
+	v.Check(validator.In(dryRun, "true", "false"), "dry_run", "must be true or false")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var rows []*importRow
+	var err error
+	switch format {
+	case "csv":
+		rows, err = readCSVBooks(r.Body)
+	case "ndjson":
+		rows, err = readNDJSONBooks(r.Body)
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = fmt.Errorf("body must not be larger than %d bytes", maxImportBytes)
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if len(rows) == 0 {
+		app.badRequestResponse(w, r, errors.New("body must contain at least one record"))
+		return
+	}
+
+	var valid []*importRow
+	for _, row := range rows {
+		if row.book == nil {
+			continue
+		}
+		v := validator.New()
+		if data.ValidateBook(v, row.book); !v.Valid() {
+			row.Status, row.Errors = importRejected, v.Errors
+			continue
+		}
+		row.Status = importValid
+		valid = append(valid, row)
+	}
+
+	if dryRun == "false" {
+		for start := 0; start < len(valid); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(valid) {
+				end = len(valid)
+			}
+			batch := valid[start:end]
+
+			books := make([]*data.Book, len(batch))
+			for i, row := range batch {
+				books[i] = row.book
+			}
+
+			// A failed batch is rolled back and reported, and the import carries on.
+			err := app.models.Books.InsertBatch(books)
+			for _, row := range batch {
+				if err != nil {
+					row.Status, row.Errors = importRejected, map[string]string{"row": "could not be saved, please try again"}
+					continue
+				}
+				row.Status, row.ID = importCreated, row.book.ID
+			}
+			if err != nil {
+				app.logError(r, err)
+			}
+		}
+	}
+
+	summary := map[string]int{importCreated: 0, importValid: 0, importRejected: 0}
+	for _, row := range rows {
+		summary[row.Status]++
+	}
+
+	env := envelope{
+		"dry_run": dryRun == "true",
+		"summary": summary,
+		"rows":    rows,
+	}
+
+	status := http.StatusOK
+	if summary[importCreated] > 0 {
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importFormat picks the import format from a Content-Type header.
+func importFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return "csv"
+	case "application/x-ndjson", "application/jsonl", "application/jsonlines":
+		return "ndjson"
+	}
+	return ""
+}
+
+var importColumns = []string{"title", "author", "year", "genres", "released_at"}
+
+func readCSVBooks(body io.Reader) ([]*importRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range importColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("header row must have a %q column", name)
+		}
+	}
+
+	var rows []*importRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			rows = append(rows, &importRow{Row: parseError.Line, Status: importRejected, Errors: map[string]string{"row": parseError.Err.Error()}})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := &importRow{Row: line, Errors: make(map[string]string)}
+		field := func(name string) string {
+			if i := columns[name]; i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		book := &data.Book{
+			Title:    field("title"),
+			Author:   field("author"),
+			Language: data.DefaultBookLanguage,
+		}
+		if i, ok := columns["language"]; ok && i < len(record) && strings.TrimSpace(record[i]) != "" {
+			book.Language = strings.TrimSpace(record[i])
+		}
+		for _, name := range []string{"year", "released_at"} {
+			value := field(name)
+			if value == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				row.Errors[name] = "must be an integer"
+				continue
+			}
+			if name == "year" {
+				book.Year = int32(n)
+			} else {
+				book.ReleasedAt = int32(n)
+			}
+		}
+		for _, genre := range strings.Split(field("genres"), "|") {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				book.Genres = append(book.Genres, genre)
+			}
+		}
+
+		if len(row.Errors) > 0 {
+			row.Status = importRejected
+		} else {
+			row.book, row.Errors = book, nil
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func readNDJSONBooks(body io.Reader) ([]*importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxImportBytes)
+
+	var rows []*importRow
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var input struct {
+			Title      string   `json:"title"`
+			Author     string   `json:"author"`
+			Year       int32    `json:"year"`
+			Genres     []string `json:"genres"`
+			ReleasedAt int32    `json:"released_at"`
+			Language   string   `json:"language"`
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&input); err != nil {
+			rows = append(rows, &importRow{Row: line, Status: importRejected, Errors: map[string]string{"row": "is not a valid book object: " + err.Error()}})
+			continue
+		}
+
+		if input.Language == "" {
+			input.Language = data.DefaultBookLanguage
+		}
+
+		rows = append(rows, &importRow{Row: line, book: &data.Book{
+			Title:      input.Title,
+			Author:     input.Author,
+			Year:       input.Year,
+			Genres:     input.Genres,
+			ReleasedAt: input.ReleasedAt,
+			Language:   input.Language,
+		}})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
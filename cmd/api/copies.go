@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/validator"
+)
+
+func (app *application) createCopy(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Books.Get(bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		BranchID  *int64 `json:"branch_id"`
+		Barcode   string `json:"barcode"`
+		ItemType  string `json:"item_type"`
+		Condition string `json:"condition"`
+		Location  string `json:"location"`
+		Status    string `json:"status"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	c := &data.Copy{
+		BookID:    bookID,
+		BranchID:  input.BranchID,
+		Barcode:   input.Barcode,
+		ItemType:  input.ItemType,
+		Condition: input.Condition,
+		Location:  input.Location,
+		Status:    input.Status,
+	}
+	if c.ItemType == "" {
+		c.ItemType = "book"
+	}
+	if c.Status == "" {
+		c.Status = data.CopyStatusAvailable
+	}
+
+	v := validator.New()
+
+	v.Check(validator.In(c.Status, data.CopyShelfStatuses...), "status", "invalid status value")
+	if err := app.checkBranch(v, "branch_id", c.BranchID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateCopy(v, c); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hold, err := app.models.Copies.Insert(c)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateBarcode):
+			v.AddError("barcode", "a copy with this barcode already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if hold != nil {
+		app.notifyHoldReady(hold)
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"copy": c}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCopies(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Books.Get(bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	copies, err := app.models.Copies.GetAllForBook(bookID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"copies": copies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCopy(w http.ResponseWriter, r *http.Request) {
+	c, err := app.readCopy(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"copy": c}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCopy(w http.ResponseWriter, r *http.Request) {
+	c, err := app.readCopy(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		BranchID  *int64  `json:"branch_id"`
+		Barcode   *string `json:"barcode"`
+		ItemType  *string `json:"item_type"`
+		Condition *string `json:"condition"`
+		Location  *string `json:"location"`
+		Status    *string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.BranchID != nil {
+		c.BranchID = input.BranchID
+	}
+	if input.Barcode != nil {
+		c.Barcode = *input.Barcode
+	}
+	if input.ItemType != nil {
+		c.ItemType = *input.ItemType
+	}
+	if input.Condition != nil {
+		c.Condition = *input.Condition
+	}
+	if input.Location != nil {
+		c.Location = *input.Location
+	}
+
+	v := validator.New()
+
+	if input.Status != nil {
+		v.Check(validator.In(c.Status, data.CopyShelfStatuses...), "status", "cannot be changed while the copy is in circulation")
+		v.Check(validator.In(*input.Status, data.CopyShelfStatuses...), "status", "invalid status value")
+		c.Status = *input.Status
+	}
+
+	if err := app.checkBranch(v, "branch_id", input.BranchID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateCopy(v, c); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hold, err := app.models.Copies.Update(c)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateBarcode):
+			v.AddError("barcode", "a copy with this barcode already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if hold != nil {
+		app.notifyHoldReady(hold)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"copy": c}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCopy(w http.ResponseWriter, r *http.Request) {
+	c, err := app.readCopy(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Copies.Delete(c.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrCopyInCirculation):
+			v := validator.New()
+			v.AddError("status", "cannot delete a copy while it is in circulation")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "copy was successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readCopy loads the copy named by the :copy_id parameter and makes sure it belongs to the
+// book named by :id. A copy of some other book is reported as ErrRecordNotFound.
+func (app *application) readCopy(r *http.Request) (*data.Copy, error) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		return nil, data.ErrRecordNotFound
+	}
+	copyID, err := app.readInt64Param(r, "copy_id")
+	if err != nil {
+		return nil, data.ErrRecordNotFound
+	}
+
+	c, err := app.models.Copies.Get(copyID)
+	if err != nil {
+		return nil, err
+	}
+	if c.BookID != bookID {
+		return nil, data.ErrRecordNotFound
+	}
+	return c, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/mailer"
+	"github.com/Eldiai/go_library/internal/validator"
+)
+
+// emailQueue puts the mailer's messages in the emails table, for deliverEmails to send.
+type emailQueue struct {
+	emails interface {
+		Insert(email *data.Email) error
+	}
+}
+
+func (q emailQueue) Enqueue(msg *mailer.Message) error {
+	return q.emails.Insert(&data.Email{
+		Recipient: msg.Recipient,
+		Subject:   msg.Subject,
+		PlainBody: msg.PlainBody,
+		HTMLBody:  msg.HTMLBody,
+	})
+}
+
+// deliverEmails returns a job that sends the queued emails which are due. Failed emails are
+// retried after backoff, doubled for every attempt, until they run out of attempts.
+func (app *application) deliverEmails(backoff time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		emails, err := app.models.Emails.ClaimDue(ctx, app.config.Outbox.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, email := range emails {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			deliveryErr := app.mailer.Deliver(&mailer.Message{
+				Recipient: email.Recipient,
+				Subject:   email.Subject,
+				PlainBody: email.PlainBody,
+				HTMLBody:  email.HTMLBody,
+			})
+			if deliveryErr == nil {
+				err = app.models.Emails.MarkSent(email)
+			} else {
+				err = app.models.Emails.MarkFailed(email, deliveryErr, app.config.Outbox.MaxAttempts, backoff)
+				if email.Status == data.EmailStatusDead {
+					app.logger.PrintError(deliveryErr, map[string]string{"email_id": strconv.FormatInt(email.ID, 10)})
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (app *application) listEmails(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", data.EmailStatusDead)
+	limit := app.readInt(qs, "limit", 100, v)
+
+	v.Check(validator.In(status, data.EmailStatusPending, data.EmailStatusSent, data.EmailStatusDead), "status", "invalid status value")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 500, "limit", "must be a maximum of 500")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	emails, err := app.models.Emails.GetAllByStatus(status, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"emails": emails}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resendEmail puts a dead email back in the queue with a fresh set of attempts.
+func (app *application) resendEmail(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	email, err := app.models.Emails.Requeue(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"email": email}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
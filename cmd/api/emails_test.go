@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Eldiai/go_library/config"
+	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/jsonlog"
+	"github.com/Eldiai/go_library/internal/mailer"
+)
+
+// smtpStub is a minimal SMTP server which rejects the first failures messages it is sent with
+// a temporary error, and keeps the rest.
+type smtpStub struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	failures int
+	messages []string
+}
+
+func newSMTPStub(t *testing.T, failures int) *smtpStub {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &smtpStub{ln: ln, failures: failures}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStub) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStub) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+
+	reply("220 stub ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250-stub")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(cmd, "HELO"), strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"),
+			strings.HasPrefix(cmd, "RSET"), strings.HasPrefix(cmd, "NOOP"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(line)
+			}
+
+			s.mu.Lock()
+			if s.failures > 0 {
+				s.failures--
+				s.mu.Unlock()
+				reply("451 try again later")
+				continue
+			}
+			s.messages = append(s.messages, msg.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// fakeEmails keeps the outbox in memory, applying the same failure rules as the database.
+type fakeEmails struct {
+	emails []*data.Email
+}
+
+func (f *fakeEmails) Insert(email *data.Email) error {
+	email.ID = int64(len(f.emails) + 1)
+	email.Status = data.EmailStatusPending
+	email.NextAttemptAt = time.Now()
+	f.emails = append(f.emails, email)
+	return nil
+}
+
+func (f *fakeEmails) ClaimDue(ctx context.Context, limit int) ([]*data.Email, error) {
+	due := []*data.Email{}
+	for _, email := range f.emails {
+		if len(due) < limit && email.Status == data.EmailStatusPending && !email.NextAttemptAt.After(time.Now()) {
+			due = append(due, email)
+		}
+	}
+	return due, nil
+}
+
+func (f *fakeEmails) GetAllByStatus(status string, limit int) ([]*data.Email, error) {
+	return nil, nil
+}
+
+func (f *fakeEmails) MarkSent(email *data.Email) error {
+	now := time.Now()
+	email.Attempts++
+	email.Status, email.SentAt, email.LastError = data.EmailStatusSent, &now, ""
+	email.PlainBody, email.HTMLBody = "", ""
+	return nil
+}
+
+func (f *fakeEmails) MarkFailed(email *data.Email, deliveryErr error, maxAttempts int, backoff time.Duration) error {
+	email.RecordFailure(deliveryErr, maxAttempts, backoff)
+	return nil
+}
+
+func (f *fakeEmails) Requeue(id int64) (*data.Email, error) {
+	return nil, data.ErrRecordNotFound
+}
+
+func (f *fakeEmails) DeleteSettled(ctx context.Context, age time.Duration) (int64, error) {
+	return 0, nil
+}
+
+// newOutboxApp returns an application which queues email in emails and delivers it to stub.
+func newOutboxApp(stub *smtpStub, emails *fakeEmails, maxAttempts int) *application {
+	app := &application{
+		config: &config.Config{Outbox: &config.Outbox{Enabled: true, BatchSize: 10, MaxAttempts: maxAttempts}},
+		logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelOff),
+		mailer: mailer.New("127.0.0.1", stub.port(), "", "", "Library <library@example.com>"),
+	}
+	app.models.Emails = emails
+	app.mailer = app.mailer.WithQueue(emailQueue{emails: emails})
+	return app
+}
+
+func queueTestEmail(t *testing.T, emails *fakeEmails) *data.Email {
+	t.Helper()
+
+	email := &data.Email{
+		Recipient: "patron@example.com",
+		Subject:   "Your hold is ready",
+		PlainBody: "Come and pick it up.",
+		HTMLBody:  "<p>Come and pick it up.</p>",
+	}
+	if err := emails.Insert(email); err != nil {
+		t.Fatal(err)
+	}
+	return email
+}
+
+func TestDeliverEmailsRetriesAfterFailure(t *testing.T) {
+	stub := newSMTPStub(t, 1)
+	emails := &fakeEmails{}
+	app := newOutboxApp(stub, emails, 3)
+	deliver := app.deliverEmails(time.Minute)
+
+	email := queueTestEmail(t, emails)
+
+	if err := deliver(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if email.Status != data.EmailStatusPending || email.Attempts != 1 || email.LastError == "" {
+		t.Fatalf("after a rejected attempt: status %q, attempts %d, last error %q", email.Status, email.Attempts, email.LastError)
+	}
+	if wait := time.Until(email.NextAttemptAt); wait < 50*time.Second || wait > time.Minute {
+		t.Errorf("next attempt in %s; want about a minute", wait)
+	}
+
+	// The email isn't due again yet, so another run leaves it alone.
+	if err := deliver(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if email.Attempts != 1 {
+		t.Fatalf("email was retried before its backoff: attempts %d", email.Attempts)
+	}
+
+	email.NextAttemptAt = time.Now()
+	if err := deliver(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if email.Status != data.EmailStatusSent || email.Attempts != 2 {
+		t.Fatalf("after the retry: status %q, attempts %d", email.Status, email.Attempts)
+	}
+
+	messages := stub.received()
+	if len(messages) != 1 {
+		t.Fatalf("stub received %d messages; want 1", len(messages))
+	}
+	for _, want := range []string{"To: patron@example.com", "Subject: Your hold is ready", "Come and pick it up."} {
+		if !strings.Contains(messages[0], want) {
+			t.Errorf("message is missing %q:\n%s", want, messages[0])
+		}
+	}
+}
+
+func TestDeliverEmailsGivesUpAfterMaxAttempts(t *testing.T) {
+	stub := newSMTPStub(t, 100)
+	emails := &fakeEmails{}
+	app := newOutboxApp(stub, emails, 3)
+	deliver := app.deliverEmails(time.Minute)
+
+	email := queueTestEmail(t, emails)
+
+	for i := 1; i <= 5; i++ {
+		email.NextAttemptAt = time.Now()
+		if err := deliver(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if email.Status != data.EmailStatusDead || email.Attempts != 3 {
+		t.Errorf("status %q, attempts %d; want %q after 3 attempts", email.Status, email.Attempts, data.EmailStatusDead)
+	}
+	if n := len(stub.received()); n != 0 {
+		t.Errorf("stub received %d messages; want none", n)
+	}
+}
+
+func TestMailerSendQueuesForDelivery(t *testing.T) {
+	stub := newSMTPStub(t, 0)
+	emails := &fakeEmails{}
+	app := newOutboxApp(stub, emails, 3)
+
+	err := app.mailer.Send("patron@example.com", "token_activation.tmpl", map[string]interface{}{
+		"activationToken": strings.Repeat("A", 26),
+		"userName":        "Patron",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails.emails) != 1 || len(stub.received()) != 0 {
+		t.Fatalf("Send should queue the email, not deliver it: %d queued, %d delivered", len(emails.emails), len(stub.received()))
+	}
+
+	if err := app.deliverEmails(time.Minute)(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := emails.emails[0].Status; got != data.EmailStatusSent {
+		t.Errorf("status %q; want %q", got, data.EmailStatusSent)
+	}
+	if n := len(stub.received()); n != 1 {
+		t.Errorf("stub received %d messages; want 1", n)
+	}
+}
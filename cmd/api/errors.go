@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type envelope map[string]interface{}
@@ -54,11 +56,32 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
-func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since you fetched it, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// rateLimitExceededResponse tells the client how long to wait before trying again. Callers
+// set the RateLimit-Limit header.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := strconv.Itoa(ceilSeconds(retryAfter))
+	w.Header().Set("RateLimit-Remaining", "0")
+	w.Header().Set("RateLimit-Reset", seconds)
+	w.Header().Set("Retry-After", seconds)
+
 	message := "rate limited exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+// serverBusyResponse turns away a request for work the server is already doing as much of
+// as it can, such as exports.
+func (app *application) serverBusyResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+
+	message := "the server is busy, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -82,3 +105,8 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) outstandingFinesResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your outstanding fines must be paid before you can check out"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
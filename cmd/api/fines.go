@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/validator"
+)
+
+func (app *application) listUserFines(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	app.writeFines(w, r, user.ID)
+}
+
+func (app *application) listFines(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	userID := app.readInt(r.URL.Query(), "user_id", 0, v)
+	if v.Check(userID > 0, "user_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.writeFines(w, r, int64(userID))
+}
+
+func (app *application) writeFines(w http.ResponseWriter, r *http.Request, userID int64) {
+	fines, err := app.models.Fines.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	balance, err := app.models.Fines.Balance(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"fines": fines, "balance": balance}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPayment(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID int64  `json:"user_id"`
+		Amount int64  `json:"amount"`
+		Note   string `json:"note"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+	v.Check(len(input.Note) <= 500, "note", "must not be more than 500 bytes long")
+	if data.ValidateFineAmount(v, input.Amount); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err := app.models.Users.Get(input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "no user with this id exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	staff := app.contextGetUser(r)
+
+	fine, err := app.models.Fines.RecordPayment(input.UserID, input.Amount, input.Note, staff.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"fine": fine}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createWaiver(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ChargeID int64  `json:"charge_id"`
+		Amount   int64  `json:"amount"`
+		Note     string `json:"note"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.ChargeID > 0, "charge_id", "must be provided")
+	v.Check(len(input.Note) <= 500, "note", "must not be more than 500 bytes long")
+	// Leaving the amount out waives whatever is left of the charge.
+	if input.Amount != 0 {
+		data.ValidateFineAmount(v, input.Amount)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	staff := app.contextGetUser(r)
+
+	fine, err := app.models.Fines.Waive(input.ChargeID, input.Amount, input.Note, staff.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("charge_id", "no charge with this id exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrFineSettled):
+			v.AddError("amount", "must not be more than what is left of the charge")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"fine": fine}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
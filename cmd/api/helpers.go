@@ -1,25 +1,168 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Eldiai/go_library/internal/validator"
 	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+var errInvalidAuthorizationHeader = errors.New("invalid authorization header")
+
+// bearerToken returns the token from the request's "Authorization: Bearer <token>" header, or
+// an empty string if there is no Authorization header.
+func (app *application) bearerToken(r *http.Request) (string, error) {
+	authorizationHeader := r.Header.Get("Authorization")
+	if authorizationHeader == "" {
+		return "", nil
+	}
+
+	headerParts := strings.Split(authorizationHeader, " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return "", errInvalidAuthorizationHeader
+	}
+	return headerParts[1], nil
+}
+
+// truncate shortens s to at most n bytes, without splitting a UTF-8 character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// ceilSeconds rounds d up to whole seconds, for headers such as Retry-After.
+func ceilSeconds(d time.Duration) int {
+	seconds := int(d / time.Second)
+	if d%time.Second > 0 {
+		seconds++
+	}
+	return seconds
+}
+
+// parseTrustedProxies parses a list of IP addresses and CIDR ranges.
+func parseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an IP address or CIDR range", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an IP address or CIDR range", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (app *application) isTrustedProxy(ip net.IP) bool {
+	for _, network := range app.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that made the request. When the request comes
+// through a trusted proxy, the client is the last address in X-Forwarded-For that isn't one
+// of our proxies.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !app.isTrustedProxy(ip) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !app.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// versionETag returns the strong entity tag for a record at the given version.
+func versionETag(version int32) string {
+	return `"` + strconv.FormatInt(int64(version), 10) + `"`
+}
+
+// ifMatchVersion reports whether the request's If-Match header, if it has one, names the
+// given version of a record. Entity tags may carry more after a ".", such as the availability
+// in a book's tag, which doesn't take part in the comparison. Without an If-Match header it
+// returns true.
+func ifMatchVersion(r *http.Request, version int32) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	want := strconv.FormatInt(int64(version), 10)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		tag = strings.Trim(tag, `"`)
+		if i := strings.IndexByte(tag, '.'); i >= 0 {
+			tag = tag[:i]
+		}
+		if tag == want {
+			return true
+		}
+	}
+	return false
+}
+
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readInt64Param(r, "id")
+}
+
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -48,6 +191,58 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// jsonETag returns a strong entity tag for the JSON that writeJSON would send for data.
+func jsonETag(data envelope) (string, error) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(js)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// cacheControl returns a Cache-Control value that lets clients reuse a response for maxAge.
+// Responses depend on who is asking, so only the client may cache them; with no max age it
+// must revalidate every time.
+func cacheControl(maxAge time.Duration) string {
+	if maxAge <= 0 {
+		return "private, no-cache"
+	}
+	return "private, max-age=" + strconv.Itoa(int(maxAge/time.Second))
+}
+
+// notModified reports whether the copy the client already has is current, going by
+// If-None-Match, or by If-Modified-Since when there is no If-None-Match. A zero lastModified
+// is never considered.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if header := r.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		if err == nil && !lastModified.Truncate(time.Second).After(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified answers a conditional GET with a 304, which repeats the validators and
+// caching headers but has no body.
+func (app *application) writeNotModified(w http.ResponseWriter, headers http.Header) {
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
@@ -145,6 +340,38 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readInt32 is readInt for values stored as 32-bit integers, such as years. Values outside
+// that range are reported rather than wrapped around.
+func (app *application) readInt32(qs url.Values, key string, defaultValue int32, v *validator.Validator) int32 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	i, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			v.AddError(key, fmt.Sprintf("must be between %d and %d", math.MinInt32, math.MaxInt32))
+		} else {
+			v.AddError(key, "must be an integer value")
+		}
+		return defaultValue
+	}
+	return int32(i)
+}
+
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+	return f
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 
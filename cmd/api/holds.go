@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/validator"
+)
+
+func (app *application) createHold(w http.ResponseWriter, r *http.Request) {
+	bookID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	hold, err := app.models.Holds.Place(bookID, user.ID, user.BranchID)
+	if err != nil {
+		v := validator.New()
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrCopiesAvailable):
+			v.AddError("book", "has copies available, please check one out instead")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateHold):
+			v.AddError("book", "you already have a hold on this book")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"hold": hold}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteHold(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	hold, err := app.models.Holds.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if hold.UserID != user.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	next, err := app.models.Holds.Cancel(hold)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrHoldClosed):
+			v := validator.New()
+			v.AddError("hold", "is no longer active")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if next != nil {
+		app.notifyHoldReady(next)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "hold was successfully cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserHolds(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	holds, err := app.models.Holds.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"holds": holds}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// expireHolds closes holds whose pickup window has passed and notifies the patrons the copies
+// were passed on to. It stops between holds once ctx is cancelled at shutdown.
+func (app *application) expireHolds(ctx context.Context) error {
+	holds, err := app.models.Holds.Expire(ctx)
+	for _, hold := range holds {
+		app.notifyHoldReady(hold)
+	}
+	return err
+}
+
+// notifyHoldReady emails a patron, in the background, that a copy is waiting for them.
+func (app *application) notifyHoldReady(hold *data.Hold) {
+	app.background(func() {
+		user, err := app.models.Users.Get(hold.UserID)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+		book, err := app.models.Books.Get(hold.BookID)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		data := map[string]interface{}{
+			"holdID":    hold.ID,
+			"userName":  user.Name,
+			"bookTitle": book.Title,
+			"expiresAt": hold.ExpiresAt.Format(time.RFC1123),
+		}
+
+		err = app.mailer.Send(user.Email, "hold_ready.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/ratelimit"
+	"github.com/Eldiai/go_library/internal/scheduler"
+)
+
+const (
+	// dueReminderWindow is how long before its due date a borrower is reminded of a loan.
+	dueReminderWindow = 48 * time.Hour
+	// overdueNoticeInterval is how often a borrower is nagged about an overdue loan.
+	overdueNoticeInterval = 7 * 24 * time.Hour
+	// rateLimitPurgeInterval is how often expired counts are deleted from the rate_limits table.
+	rateLimitPurgeInterval = 10 * time.Minute
+	// emailPurgeInterval is how often settled emails are deleted from the outbox.
+	emailPurgeInterval = time.Hour
+	// emailRetention is how long sent and dead emails stay in the outbox, for the admin listing
+	// and for requeueing, before they are purged. It outlasts every token an email can carry.
+	emailRetention = 7 * 24 * time.Hour
+)
+
+// startScheduler registers the built-in jobs named in the configuration and starts running
+// them. The jobs stop when ctx is cancelled, and are tracked by app.wg.
+func (app *application) startScheduler(ctx context.Context) error {
+	jobs := map[string]func(ctx context.Context) error{
+		"purge_expired_tokens": app.purgeExpiredTokens,
+		"send_due_reminders":   app.sendDueReminders,
+		"send_overdue_notices": app.sendOverdueNotices,
+		"expire_holds":         app.expireHolds,
+	}
+
+	if app.config.Scheduler.Enabled {
+		for name, value := range app.config.Scheduler.Jobs {
+			run, ok := jobs[name]
+			if !ok {
+				return fmt.Errorf("scheduler: unknown job %q", name)
+			}
+			interval, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("scheduler: job %q: %w", name, err)
+			}
+			if interval <= 0 {
+				return fmt.Errorf("scheduler: job %q: interval must be greater than zero", name)
+			}
+			err = app.scheduler.Add(scheduler.Job{Name: name, Interval: interval, Run: run})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The outbox has its own settings, since queued email would never go out without it.
+	if app.config.Outbox.Enabled {
+		interval, err := time.ParseDuration(app.config.Outbox.Interval)
+		if err != nil {
+			return fmt.Errorf("outbox: interval: %w", err)
+		}
+		if interval <= 0 {
+			return errors.New("outbox: interval must be greater than zero")
+		}
+		backoff, err := time.ParseDuration(app.config.Outbox.Backoff)
+		if err != nil {
+			return fmt.Errorf("outbox: backoff: %w", err)
+		}
+		if backoff <= 0 {
+			return errors.New("outbox: backoff must be greater than zero")
+		}
+		err = app.scheduler.Add(scheduler.Job{Name: "deliver_emails", Interval: interval, Run: app.deliverEmails(backoff)})
+		if err != nil {
+			return err
+		}
+		err = app.scheduler.Add(scheduler.Job{Name: "purge_emails", Interval: emailPurgeInterval, Run: app.purgeEmails})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Counts in the shared rate limiter have to be cleared out by somebody.
+	if limiter, ok := app.limiter.(*ratelimit.Postgres); ok {
+		err := app.scheduler.Add(scheduler.Job{Name: "purge_rate_limits", Interval: rateLimitPurgeInterval, Run: app.purgeRateLimits(limiter)})
+		if err != nil {
+			return err
+		}
+	}
+
+	app.scheduler.Start(ctx, &app.wg)
+	return nil
+}
+
+func (app *application) listJobs(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"jobs": app.scheduler.Statuses()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) purgeExpiredTokens(ctx context.Context) error {
+	deleted, err := app.models.Tokens.DeleteExpired(ctx)
+	if err != nil {
+		return err
+	}
+	app.logger.PrintInfo("expired tokens purged", map[string]string{"deleted": strconv.FormatInt(deleted, 10)})
+	return nil
+}
+
+func (app *application) purgeEmails(ctx context.Context) error {
+	deleted, err := app.models.Emails.DeleteSettled(ctx, emailRetention)
+	if err != nil {
+		return err
+	}
+	app.logger.PrintInfo("settled emails purged", map[string]string{"deleted": strconv.FormatInt(deleted, 10)})
+	return nil
+}
+
+func (app *application) purgeRateLimits(limiter *ratelimit.Postgres) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		deleted, err := limiter.DeleteExpired(ctx)
+		if err != nil {
+			return err
+		}
+		app.logger.PrintInfo("expired rate limits purged", map[string]string{"deleted": strconv.FormatInt(deleted, 10)})
+		return nil
+	}
+}
+
+func (app *application) sendDueReminders(ctx context.Context) error {
+	notices, err := app.models.Loans.GetDueSoon(ctx, dueReminderWindow)
+	if err != nil {
+		return err
+	}
+	return app.sendLoanNotices(ctx, notices, "loan_due_soon.tmpl", app.models.Loans.MarkReminded)
+}
+
+func (app *application) sendOverdueNotices(ctx context.Context) error {
+	notices, err := app.models.Loans.GetOverdue(ctx, overdueNoticeInterval)
+	if err != nil {
+		return err
+	}
+	return app.sendLoanNotices(ctx, notices, "loan_overdue.tmpl", app.models.Loans.MarkOverdueNotified)
+}
+
+// sendLoanNotices emails each borrower and marks the loan as notified. A failed email is
+// logged and retried on the next run; it doesn't hold up the rest. Marking a loan isn't tied
+// to ctx, so an email that went out at shutdown is still recorded and not sent twice.
+func (app *application) sendLoanNotices(ctx context.Context, notices []*data.LoanNotice, templateFile string, mark func(int64) error) error {
+	for _, notice := range notices {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		data := map[string]interface{}{
+			"loanID":    notice.LoanID,
+			"userName":  notice.UserName,
+			"bookTitle": notice.BookTitle,
+			"dueAt":     notice.DueAt.Format(time.RFC1123),
+		}
+
+		err := app.mailer.Send(notice.UserEmail, templateFile, data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"loan_id": strconv.FormatInt(notice.LoanID, 10)})
+			continue
+		}
+
+		err = mark(notice.LoanID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
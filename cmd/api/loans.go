@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/validator"
+)
+
+func (app *application) createLoan(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CopyID int64 `json:"copy_id"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.CopyID > 0, "copy_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	balance, err := app.models.Fines.Balance(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if balance.Total > app.config.Fines.BlockThreshold {
+		app.outstandingFinesResponse(w, r)
+		return
+	}
+
+	loan, err := app.models.Loans.Checkout(input.CopyID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("copy_id", "no copy with this id exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCopyUnavailable):
+			v.AddError("copy_id", "this copy is not available for checkout")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"loan": loan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) renewLoan(w http.ResponseWriter, r *http.Request) {
+	loan, err := app.readLoan(r, false)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	err = app.models.Loans.Renew(loan)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrLoanReturned):
+			v.AddError("loan", "has already been returned")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrLoanOverdue):
+			v.AddError("loan", "is overdue and must be returned")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRenewalLimit):
+			v.AddError("loan", "has reached the renewal limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrHoldsPending):
+			v.AddError("loan", "cannot be renewed while other patrons are waiting for this book")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"loan": loan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) returnLoan(w http.ResponseWriter, r *http.Request) {
+	loan, err := app.readLoan(r, true)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	hold, err := app.models.Loans.Return(loan)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrLoanReturned):
+			v := validator.New()
+			v.AddError("loan", "has already been returned")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if hold != nil {
+		app.notifyHoldReady(hold)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"loan": loan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readLoan loads the loan named by the :id parameter. Patrons can only see their own loans;
+// when staff is true, users with the "books:write" permission can see everybody's. Loans
+// the user may not see are reported as ErrRecordNotFound.
+func (app *application) readLoan(r *http.Request, staff bool) (*data.Loan, error) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		return nil, data.ErrRecordNotFound
+	}
+
+	loan, err := app.models.Loans.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	user := app.contextGetUser(r)
+	if loan.UserID == user.ID {
+		return loan, nil
+	}
+
+	if staff {
+		permissions, err := app.userPermissions(r)
+		if err != nil {
+			return nil, err
+		}
+		if permissions.Include("books:write") {
+			return loan, nil
+		}
+	}
+
+	return nil, data.ErrRecordNotFound
+}
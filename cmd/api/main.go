@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,6 +16,8 @@ import (
 	"github.com/Eldiai/go_library/internal/data"
 	"github.com/Eldiai/go_library/internal/jsonlog"
 	"github.com/Eldiai/go_library/internal/mailer"
+	"github.com/Eldiai/go_library/internal/ratelimit"
+	"github.com/Eldiai/go_library/internal/scheduler"
 
 	_ "github.com/jackc/pgx/v5/stdlib" // for compatibility with database/sql
 )
@@ -20,11 +25,19 @@ import (
 const version = "1.0.0"
 
 type application struct {
-	config *config.Config
-	logger *jsonlog.Logger
-	mailer mailer.Mailer
-	models data.Models
-	wg     sync.WaitGroup
+	config             *config.Config
+	logger             *jsonlog.Logger
+	mailer             mailer.Mailer
+	models             data.Models
+	scheduler          *scheduler.Scheduler
+	permissions        *permissionCache
+	exports            chan struct{}
+	booksMaxAge        time.Duration
+	limiter            ratelimit.RateLimiter
+	anonymousLimit     ratelimit.Limit
+	authenticatedLimit ratelimit.Limit
+	trustedProxies     []*net.IPNet
+	wg                 sync.WaitGroup
 }
 
 func main() {
@@ -45,10 +58,33 @@ func main() {
 	logger.PrintInfo("database connection pool established", nil)
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.Smtp.Host, cfg.Smtp.Port, cfg.Smtp.Username, cfg.Smtp.Password, cfg.Smtp.Sender),
+		config:    cfg,
+		logger:    logger,
+		models:    data.NewModels(db),
+		mailer:    mailer.New(cfg.Smtp.Host, cfg.Smtp.Port, cfg.Smtp.Username, cfg.Smtp.Password, cfg.Smtp.Sender),
+		scheduler: scheduler.New(logger),
+		exports:   make(chan struct{}, maxConcurrentExports),
+	}
+	permissionCacheTTL, err := time.ParseDuration(cfg.Permissions.CacheTTL)
+	if err != nil {
+		logger.PrintFatal(fmt.Errorf("permissions: cache ttl: %w", err), nil)
+	}
+	app.permissions = newPermissionCache(permissionCacheTTL)
+
+	app.booksMaxAge, err = time.ParseDuration(cfg.HTTPCache.BooksMaxAge)
+	if err != nil {
+		logger.PrintFatal(fmt.Errorf("http cache: books max age: %w", err), nil)
+	}
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	err = app.setupRateLimit(jobsCtx, db)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	if cfg.Outbox.Enabled {
+		app.mailer = app.mailer.WithQueue(emailQueue{emails: app.models.Emails})
 	}
 
 	srv := &http.Server{
@@ -59,9 +95,14 @@ func main() {
 		IdleTimeout:  15 * time.Second,
 	}
 
+	err = app.startScheduler(jobsCtx)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	go func() {
 		app.logger.PrintInfo("starting server on "+cfg.Port, nil)
-		if err := srv.ListenAndServe(); err != nil {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			app.logger.PrintFatal(err, nil)
 		}
 	}()
@@ -76,6 +117,13 @@ func main() {
 	if err != nil {
 		app.logger.PrintFatal(err, nil)
 	}
+
+	// Stop the scheduler and wait for running jobs and background emails to finish.
+	app.logger.PrintInfo("completing background tasks", nil)
+	stopJobs()
+	app.wg.Wait()
+
+	app.logger.PrintInfo("stopped server", nil)
 }
 
 func openDB(cfg *config.Config) (*sql.DB, error) {
@@ -106,3 +154,51 @@ func openDB(cfg *config.Config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// setupRateLimit creates the rate limiter named in the configuration. The limiter is created
+// even when request limits are switched off, since per-address throttles such as the one on
+// activation emails depend on it. The in-memory limiter stops sweeping for idle clients when
+// ctx is cancelled.
+func (app *application) setupRateLimit(ctx context.Context, db *sql.DB) error {
+	var err error
+
+	app.trustedProxies, err = parseTrustedProxies(app.config.RateLimit.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	switch app.config.RateLimit.Backend {
+	case "memory", "":
+		app.limiter = ratelimit.NewMemory(ctx)
+	case "postgres":
+		app.limiter = ratelimit.NewPostgres(db)
+	default:
+		return fmt.Errorf("rate limit: unknown backend %q", app.config.RateLimit.Backend)
+	}
+
+	if !app.config.RateLimit.Enabled {
+		return nil
+	}
+
+	app.anonymousLimit, err = parseRequestLimit(app.config.RateLimit.Anonymous)
+	if err != nil {
+		return fmt.Errorf("rate limit: anonymous: %w", err)
+	}
+	app.authenticatedLimit, err = parseRequestLimit(app.config.RateLimit.Authenticated)
+	if err != nil {
+		return fmt.Errorf("rate limit: authenticated: %w", err)
+	}
+	return nil
+}
+
+func parseRequestLimit(cfg config.RequestLimit) (ratelimit.Limit, error) {
+	window, err := time.ParseDuration(cfg.Window)
+	if err != nil {
+		return ratelimit.Limit{}, err
+	}
+	limit := ratelimit.Limit{Requests: cfg.Requests, Window: window}
+	if err := limit.Validate(); err != nil {
+		return ratelimit.Limit{}, err
+	}
+	return limit, nil
+}
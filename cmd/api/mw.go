@@ -4,11 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/ratelimit"
 	"github.com/Eldiai/go_library/internal/validator"
 	"net/http"
-	"strings"
-
-	"golang.org/x/time/rate"
+	"strconv"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -23,55 +22,131 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	limiter := rate.NewLimiter(2, 4)
+// rateLimitIP counts requests without a token by IP address, against the anonymous limit. A
+// token only earns the authenticated limit once authenticate has accepted it, so until then
+// requests with one are held to the anonymous limit too: authenticate counts every token it
+// rejects against the address, and a request with a token is turned away here, before the
+// token is looked up, once the address has used its limit up. Guessing tokens is therefore no
+// faster than anonymous browsing, and shares its budget.
+func (app *application) rateLimitIP(next http.Handler) http.Handler {
+	if !app.config.RateLimit.Enabled {
+		return next
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !limiter.Allow() {
-			app.rateLimitExceededResponse(w, r)
+		key := "ip:" + app.clientIP(r)
+
+		if r.Header.Get("Authorization") == "" {
+			if app.allowRequest(w, r, key, app.anonymousLimit) {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
+		result, err := app.limiter.Check(r.Context(), key, app.anonymousLimit)
+		if err != nil {
+			// Don't turn everybody away when the limiter's backend is down.
+			app.logError(r, err)
+		} else if !result.Allowed {
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			app.rateLimitExceededResponse(w, r, result.RetryAfter)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (app *application) authenticate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// countFailedAuthentication counts a request whose token was rejected against its address's
+// anonymous limit, as described by rateLimitIP.
+func (app *application) countFailedAuthentication(r *http.Request) {
+	if !app.config.RateLimit.Enabled {
+		return
+	}
 
-		w.Header().Add("Vary", "Authorization")
+	_, err := app.limiter.Allow(r.Context(), "ip:"+app.clientIP(r), app.anonymousLimit)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
 
-		authorizationHeader := r.Header.Get("Authorization")
+// rateLimitUser counts requests from signed in users against the authenticated limit, so that
+// a user's limit is the same from every address. It must run after authenticate.
+func (app *application) rateLimitUser(next http.Handler) http.Handler {
+	if !app.config.RateLimit.Enabled {
+		return next
+	}
 
-		if authorizationHeader == "" {
-			r = app.contextSetUser(r, data.AnonymousUser)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() || app.allowRequest(w, r, "user:"+strconv.FormatInt(user.ID, 10), app.authenticatedLimit) {
 			next.ServeHTTP(w, r)
-			return
 		}
+	})
+}
+
+// allowRequest counts a request from key against limit and sets the RateLimit headers. When
+// the request is over the limit, it sends the response and returns false.
+func (app *application) allowRequest(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit) bool {
+	result, err := app.limiter.Allow(r.Context(), key, limit)
+	if err != nil {
+		// Don't turn everybody away when the limiter's backend is down.
+		app.logError(r, err)
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
 
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+	if !result.Allowed {
+		app.rateLimitExceededResponse(w, r, result.RetryAfter)
+		return false
+	}
+
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	return true
+}
+
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Add("Vary", "Authorization")
+
+		token, err := app.bearerToken(r)
+		if err != nil {
+			app.countFailedAuthentication(r)
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
-		token := headerParts[1]
+		if token == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		v := validator.New()
 
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.countFailedAuthentication(r)
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, permissions, err := app.models.Users.GetForSession(token, app.config.Permissions.LoadWithUser)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
+				app.countFailedAuthentication(r)
 				app.invalidAuthenticationTokenResponse(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
 			return
 		}
+		if app.config.Permissions.LoadWithUser {
+			r = app.contextSetPermissions(r, permissions)
+		}
+
 		r = app.contextSetUser(r, user)
 		next.ServeHTTP(w, r)
 	})
@@ -103,8 +178,7 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.userPermissions(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -115,7 +189,28 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 			return
 		}
 
+		r = app.contextSetPermissions(r, permissions)
 		next.ServeHTTP(w, r)
 	}
 	return app.requireActivatedUser(fn)
 }
+
+// userPermissions returns the authenticated user's permissions, looking in the request
+// context and the permission cache before going to the database.
+func (app *application) userPermissions(r *http.Request) (data.Permissions, error) {
+	if permissions, ok := app.contextGetPermissions(r); ok {
+		return permissions, nil
+	}
+
+	user := app.contextGetUser(r)
+	if permissions, ok := app.permissions.Get(user.ID); ok {
+		return permissions, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	app.permissions.Set(user.ID, permissions)
+	return permissions, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Eldiai/go_library/internal/data"
+)
+
+// permissionCache remembers users' permissions for a while, so that requirePermission doesn't
+// have to query them on every request. Entries are dropped when a user's permissions change,
+// and expire after ttl so that changes made by other instances are picked up too. Expired
+// entries are swept out as new ones are added, so users who stop making requests don't stay
+// in memory for good.
+type permissionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int64]permissionCacheEntry
+	swept   time.Time
+}
+
+type permissionCacheEntry struct {
+	permissions data.Permissions
+	expires     time.Time
+}
+
+// newPermissionCache returns a cache which keeps entries for ttl. A ttl of zero disables it.
+func newPermissionCache(ttl time.Duration) *permissionCache {
+	return &permissionCache{
+		ttl:     ttl,
+		entries: make(map[int64]permissionCacheEntry),
+	}
+}
+
+func (c *permissionCache) Get(userID int64) (data.Permissions, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[userID]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, userID)
+		return nil, false
+	}
+	return entry.permissions, true
+}
+
+func (c *permissionCache) Set(userID int64, permissions data.Permissions) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	// Sweeping at most once per ttl keeps the cost of a sweep spread across many Sets.
+	if now.Sub(c.swept) >= c.ttl {
+		for id, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, id)
+			}
+		}
+		c.swept = now
+	}
+
+	c.entries[userID] = permissionCacheEntry{
+		permissions: permissions,
+		expires:     now.Add(c.ttl),
+	}
+}
+
+// Invalidate forgets a user's permissions, so they are loaded afresh on their next request.
+func (c *permissionCache) Invalidate(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Eldiai/go_library/internal/data"
+)
+
+func TestPermissionCacheSweepsExpiredEntries(t *testing.T) {
+	c := newPermissionCache(time.Minute)
+
+	for id := int64(1); id <= 100; id++ {
+		c.Set(id, data.Permissions{"books:read"})
+	}
+	for id := range c.entries {
+		entry := c.entries[id]
+		entry.expires = time.Now().Add(-time.Second)
+		c.entries[id] = entry
+	}
+	c.swept = time.Now().Add(-time.Minute)
+
+	c.Set(101, data.Permissions{"books:read"})
+
+	if len(c.entries) != 1 {
+		t.Errorf("cache holds %d entries after a sweep; want 1", len(c.entries))
+	}
+	if _, ok := c.Get(101); !ok {
+		t.Error("the entry just set is missing")
+	}
+}
+
+// The benchmarks below compare the ways requirePermission can get hold of a user's
+// permissions. Those which query the database need LIBRARY_TEST_DSN to point at a migrated
+// database, and are skipped otherwise.
+
+func BenchmarkPermissionCacheHit(b *testing.B) {
+	c := newPermissionCache(time.Minute)
+	for id := int64(1); id <= 10000; id++ {
+		c.Set(id, data.Permissions{"books:read", "books:write"})
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		id := int64(0)
+		for pb.Next() {
+			id = id%10000 + 1
+			if _, ok := c.Get(id); !ok {
+				b.Fatal("cache miss")
+			}
+		}
+	})
+}
+
+func BenchmarkGetAllForUser(b *testing.B) {
+	models, user, _ := benchmarkUser(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := models.Permissions.GetAllForUser(user.ID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetForSession(b *testing.B) {
+	models, _, token := benchmarkUser(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := models.Users.GetForSession(token.Plaintext, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkUser creates a user with a permission and an authentication token in the database
+// named by LIBRARY_TEST_DSN, and removes them when the benchmark is done.
+func benchmarkUser(b *testing.B) (data.Models, *data.User, *data.Token) {
+	b.Helper()
+
+	dsn := os.Getenv("LIBRARY_TEST_DSN")
+	if dsn == "" {
+		b.Skip("LIBRARY_TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	models := data.NewModels(db)
+
+	user := &data.User{
+		Name:      "Benchmark",
+		Email:     "benchmark-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "@example.com",
+		Activated: true,
+	}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		b.Fatal(err)
+	}
+	if err := models.Users.Insert(user); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { models.Users.Delete(user.ID) })
+
+	if err := models.Permissions.AddForUser(user.ID, "books:read"); err != nil {
+		b.Fatal(err)
+	}
+	token, err := models.Tokens.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return models, user, token
+}
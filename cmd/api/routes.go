@@ -17,13 +17,88 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/books", app.requirePermission("books:read", app.listBooks))
 	router.HandlerFunc(http.MethodPost, "/v1/books", app.requirePermission("books:write", app.createBook))
-	router.HandlerFunc(http.MethodGet, "/v1/books/:id", app.requirePermission("books:read", app.listBook))
+	router.HandlerFunc(http.MethodPost, "/v1/books/:id", app.dispatchID(map[string]http.HandlerFunc{
+		"import": app.requirePermission("books:write", app.importBooks),
+	}, app.methodNotAllowedResponse))
+	router.HandlerFunc(http.MethodGet, "/v1/books/:id", app.dispatchID(map[string]http.HandlerFunc{
+		"export":  app.requirePermission("books:read", app.exportBooks),
+		"suggest": app.requirePermission("books:read", app.suggestBooks),
+	}, app.requirePermission("books:read", app.listBook)))
 	router.HandlerFunc(http.MethodPatch, "/v1/books/:id", app.requirePermission("books:write", app.updateBook))
 	router.HandlerFunc(http.MethodDelete, "/v1/books/:id", app.requirePermission("books:write", app.deleteBook))
+
+	router.HandlerFunc(http.MethodGet, "/v1/books/:id/copies", app.requirePermission("books:write", app.listCopies))
+	router.HandlerFunc(http.MethodPost, "/v1/books/:id/copies", app.requirePermission("books:write", app.createCopy))
+	router.HandlerFunc(http.MethodGet, "/v1/books/:id/copies/:copy_id", app.requirePermission("books:write", app.listCopy))
+	router.HandlerFunc(http.MethodPatch, "/v1/books/:id/copies/:copy_id", app.requirePermission("books:write", app.updateCopy))
+	router.HandlerFunc(http.MethodDelete, "/v1/books/:id/copies/:copy_id", app.requirePermission("books:write", app.deleteCopy))
+
+	router.HandlerFunc(http.MethodPost, "/v1/books/:id/holds", app.requirePermission("books:read", app.createHold))
+	router.HandlerFunc(http.MethodDelete, "/v1/holds/:id", app.requireActivatedUser(app.deleteHold))
+
+	router.HandlerFunc(http.MethodGet, "/v1/branches", app.requirePermission("books:read", app.listBranches))
+	router.HandlerFunc(http.MethodPost, "/v1/branches", app.requirePermission("books:write", app.createBranch))
+	router.HandlerFunc(http.MethodGet, "/v1/branches/:id", app.requirePermission("books:read", app.listBranch))
+	router.HandlerFunc(http.MethodPatch, "/v1/branches/:id", app.requirePermission("books:write", app.updateBranch))
+	router.HandlerFunc(http.MethodDelete, "/v1/branches/:id", app.requirePermission("books:write", app.deleteBranch))
+
+	router.HandlerFunc(http.MethodGet, "/v1/transfers", app.requirePermission("books:write", app.listTransfers))
+	router.HandlerFunc(http.MethodPost, "/v1/transfers", app.requirePermission("books:write", app.createTransfer))
+	router.HandlerFunc(http.MethodPatch, "/v1/transfers/:id", app.requirePermission("books:write", app.updateTransfer))
+
+	router.HandlerFunc(http.MethodPost, "/v1/loans", app.requireActivatedUser(app.createLoan))
+	router.HandlerFunc(http.MethodPost, "/v1/loans/:id/renew", app.requireActivatedUser(app.renewLoan))
+	router.HandlerFunc(http.MethodPost, "/v1/loans/:id/return", app.requireActivatedUser(app.returnLoan))
+
+	router.HandlerFunc(http.MethodGet, "/v1/fines", app.requirePermission("fines:write", app.listFines))
+	router.HandlerFunc(http.MethodPost, "/v1/fines/payments", app.requirePermission("fines:write", app.createPayment))
+	router.HandlerFunc(http.MethodPost, "/v1/fines/waivers", app.requirePermission("fines:write", app.createWaiver))
+
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUser)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUser)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPassword)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChange)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUser))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireAuthenticatedUser(app.updateCurrentUser))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/holds", app.requireActivatedUser(app.listUserHolds))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/fines", app.requireActivatedUser(app.listUserFines))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.listUserSessions))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireAuthenticatedUser(app.deleteAllAuthenticationTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsers))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.listUser))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:admin", app.updateUser))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission("users:admin", app.deleteUser))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.listUserPermissions))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.grantPermissions))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.revokePermission))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.assignRoles))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:code", app.requirePermission("users:admin", app.removeRole))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/permissions", app.requirePermission("users:admin", app.listPermissions))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("users:admin", app.listRoles))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/jobs", app.requirePermission("system:admin", app.listJobs))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/emails", app.requirePermission("system:admin", app.listEmails))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/emails/:id/resend", app.requirePermission("system:admin", app.resendEmail))
+
+	return app.recoverPanic(app.rateLimitIP(app.authenticate(app.rateLimitUser(router))))
+}
 
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+// dispatchID serves routes such as POST /v1/books/import, where a fixed name takes the place
+// of an :id parameter. httprouter can't register both at the same position, so the :id route
+// hands requests for the names in routes to their handler, and anything else to fallback.
+func (app *application) dispatchID(routes map[string]http.HandlerFunc, fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := httprouter.ParamsFromContext(r.Context()).ByName("id")
+		if next, ok := routes[name]; ok {
+			next(w, r)
+			return
+		}
+		fallback(w, r)
+	}
 }
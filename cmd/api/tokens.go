@@ -3,11 +3,23 @@ package main
 import (
 	"errors"
 	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/ratelimit"
 	"github.com/Eldiai/go_library/internal/validator"
 	"net/http"
+	"strings"
 	"time"
 )
 
+const (
+	activationTokenTTL = 3 * 24 * time.Hour
+	// activationResendPeriod is how often an address can ask for a new activation token.
+	activationResendPeriod = 5 * time.Minute
+	// passwordResetResendPeriod is how often an address can ask for a password reset token.
+	passwordResetResendPeriod = 5 * time.Minute
+	// maxUserAgentLength caps the user agent stored with a session.
+	maxUserAgentLength = 256
+)
+
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Email    string `json:"email"`
@@ -46,7 +58,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := app.models.Tokens.NewSession(user.ID, 24*time.Hour, truncate(r.UserAgent(), maxUserAgentLength))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -57,3 +69,223 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// createPasswordResetTokenHandler replaces an activated user's password reset tokens with a
+// fresh one and emails it to them. Each address can ask for a token once every
+// passwordResetResendPeriod. The response is the same whether or not the address belongs to
+// an account, so that it can't be used to find out who is registered.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.throttleEmail(w, r, "password-reset", input.Email, passwordResetResendPeriod) {
+		return
+	}
+
+	env := envelope{"message": "if that address belongs to an activated account, an email will be sent to it with password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"passwordResetToken": token.Plaintext,
+				"userName":           user.Name,
+			}
+
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createActivationTokenHandler replaces a user's activation tokens with a fresh one and emails
+// it to them. Each address can ask for a token once every activationResendPeriod. As with
+// password resets, the response doesn't say whether the address belongs to an account, or whether it
+// has already been activated.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.throttleEmail(w, r, "activation", input.Email, activationResendPeriod) {
+		return
+	}
+
+	env := envelope{"message": "if that address belongs to an account which hasn't been activated, an email will be sent to it with activation instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.Activated {
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err := app.models.Tokens.New(user.ID, activationTokenTTL, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"activationToken": token.Plaintext,
+			"userName":        user.Name,
+		}
+
+		err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// throttleEmail allows one request for the given kind of email to an address in every period,
+// counted by the shared rate limiter so that the period holds across every instance. When the
+// request isn't allowed, it sends the response and returns false.
+func (app *application) throttleEmail(w http.ResponseWriter, r *http.Request, kind, email string, period time.Duration) bool {
+	limit := ratelimit.Limit{Requests: 1, Window: period}
+	result, err := app.limiter.Allow(r.Context(), kind+":"+strings.ToLower(email), limit)
+	switch {
+	case err != nil:
+		// As with request limits, don't turn everybody away when the backend is down.
+		app.logError(r, err)
+	case !result.Allowed:
+		w.Header().Set("RateLimit-Limit", "1")
+		app.rateLimitExceededResponse(w, r, result.RetryAfter)
+		return false
+	}
+	return true
+}
+
+// deleteAuthenticationTokenHandler signs out the current session by revoking its token.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := app.bearerToken(r)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.Delete(data.ScopeAuthentication, token)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been signed out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllAuthenticationTokensHandler signs the user out of every session, this one included.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been signed out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserSessions(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	token, err := app.bearerToken(r)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(user.ID, token)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
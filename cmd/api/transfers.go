@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Eldiai/go_library/internal/data"
+	"github.com/Eldiai/go_library/internal/validator"
+)
+
+func (app *application) createTransfer(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CopyID     int64 `json:"copy_id"`
+		ToBranchID int64 `json:"to_branch_id"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CopyID > 0, "copy_id", "must be provided")
+	v.Check(input.ToBranchID > 0, "to_branch_id", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.checkBranch(v, "to_branch_id", &input.ToBranchID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	transfer, err := app.models.Transfers.Request(input.CopyID, input.ToBranchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("copy_id", "no copy with this id exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCopyUnavailable):
+			v.AddError("copy_id", "only copies on the shelf can be transferred")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrSameBranch):
+			v.AddError("to_branch_id", "the copy is already at this branch")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"transfer": transfer}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listTransfers(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", "")
+	branchID := app.readInt(qs, "branch", 0, v)
+
+	if status != "" {
+		v.Check(validator.In(status,
+			data.TransferStatusRequested,
+			data.TransferStatusShipped,
+			data.TransferStatusReceived,
+			data.TransferStatusCancelled,
+		), "status", "invalid status value")
+	}
+	v.Check(branchID >= 0, "branch", "must be a valid branch id")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	transfers, err := app.models.Transfers.GetAll(status, int64(branchID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"transfers": transfers}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateTransfer moves a transfer along: "shipped" when it leaves the sending branch,
+// "received" when it arrives, or "cancelled" before it has been shipped.
+func (app *application) updateTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	transfer, err := app.models.Transfers.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	var hold *data.Hold
+	switch input.Status {
+	case data.TransferStatusShipped:
+		err = app.models.Transfers.Ship(transfer)
+	case data.TransferStatusReceived:
+		hold, err = app.models.Transfers.Receive(transfer)
+	case data.TransferStatusCancelled:
+		hold, err = app.models.Transfers.Cancel(transfer)
+	default:
+		v.AddError("status", "invalid status value")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTransferClosed):
+			v.AddError("status", "cannot be changed from "+transfer.Status)
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTransferForHold):
+			v.AddError("status", "transfers for a hold are cancelled by cancelling the hold")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if hold != nil {
+		app.notifyHoldReady(hold)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"transfer": transfer}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
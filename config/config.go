@@ -22,11 +22,72 @@ type (
 		Sender   string `json:"sender" yaml:"sender"`
 	}
 
+	Fines struct {
+		// BlockThreshold is the balance, in cents, above which a user can't check out.
+		BlockThreshold int64 `json:"blockThreshold" yaml:"blockThreshold"`
+	}
+
+	Scheduler struct {
+		Enabled bool `json:"enabled" yaml:"enabled"`
+		// Jobs maps the name of a built-in job to the interval it runs at, e.g. "1h".
+		Jobs map[string]string `json:"jobs" yaml:"jobs"`
+	}
+
+	Outbox struct {
+		// Enabled queues email in the database instead of sending it straight away.
+		Enabled bool `json:"enabled" yaml:"enabled"`
+		// Interval is how often queued email is delivered, e.g. "10s".
+		Interval    string `json:"interval" yaml:"interval"`
+		BatchSize   int    `json:"batchSize" yaml:"batchSize"`
+		MaxAttempts int    `json:"maxAttempts" yaml:"maxAttempts"`
+		// Backoff is the wait after the first failed attempt; it doubles after every attempt.
+		Backoff string `json:"backoff" yaml:"backoff"`
+	}
+
+	RequestLimit struct {
+		Requests int `json:"requests" yaml:"requests"`
+		// Window is the period the requests are counted over, e.g. "1m".
+		Window string `json:"window" yaml:"window"`
+	}
+
+	RateLimit struct {
+		Enabled bool `json:"enabled" yaml:"enabled"`
+		// Backend is "memory", which limits each instance on its own, or "postgres", which
+		// shares the limits between every instance using the database.
+		Backend string `json:"backend" yaml:"backend"`
+		// Anonymous limits each client IP address, and Authenticated each signed in user.
+		Anonymous     RequestLimit `json:"anonymous" yaml:"anonymous"`
+		Authenticated RequestLimit `json:"authenticated" yaml:"authenticated"`
+		// TrustedProxies lists the addresses or CIDR ranges of proxies whose X-Forwarded-For
+		// header is believed.
+		TrustedProxies []string `json:"trustedProxies" yaml:"trustedProxies"`
+	}
+
+	Permissions struct {
+		// CacheTTL is how long a user's permissions are cached for, e.g. "1m". Changes made
+		// on other instances can take this long to apply. Zero disables the cache.
+		CacheTTL string `json:"cacheTTL" yaml:"cacheTTL"`
+		// LoadWithUser loads permissions in the same query as the authenticated user.
+		LoadWithUser bool `json:"loadWithUser" yaml:"loadWithUser"`
+	}
+
+	HTTPCache struct {
+		// BooksMaxAge is how long clients may reuse book responses without revalidating
+		// them, e.g. "30s". Zero makes them revalidate every time.
+		BooksMaxAge string `json:"booksMaxAge" yaml:"booksMaxAge"`
+	}
+
 	Config struct {
-		Port string `json:"port" yaml:"port"`
-		Env  string `json:"env" yaml:"env"`
-		Db   *Db    `json:"db" yaml:"db"`
-		Smtp *Smtp  `json:"smtp" yaml:"smtp"`
+		Port        string       `json:"port" yaml:"port"`
+		Env         string       `json:"env" yaml:"env"`
+		Db          *Db          `json:"db" yaml:"db"`
+		Smtp        *Smtp        `json:"smtp" yaml:"smtp"`
+		Fines       *Fines       `json:"fines" yaml:"fines"`
+		Scheduler   *Scheduler   `json:"scheduler" yaml:"scheduler"`
+		Outbox      *Outbox      `json:"outbox" yaml:"outbox"`
+		RateLimit   *RateLimit   `json:"rateLimit" yaml:"rateLimit"`
+		Permissions *Permissions `json:"permissions" yaml:"permissions"`
+		HTTPCache   *HTTPCache   `json:"httpCache" yaml:"httpCache"`
 	}
 )
 
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"github.com/Eldiai/go_library/internal/validator"
 	"github.com/lib/pq"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,33 +20,79 @@ type Book struct {
 	Year       int32     `json:"year,omitempty"`
 	Genres     []string  `json:"genres,omitempty"`
 	ReleasedAt int32     `json:"released_at,omitempty"`
+	Language   string    `json:"language"`
+	Headline   string    `json:"headline,omitempty"`
+	UpdatedAt  time.Time `json:"-"`
+	Version    int32     `json:"-"`
 }
 
+// BookLanguages are the text search configurations a book can be indexed with. "simple"
+// doesn't stem words, and suits books in languages missing from the list.
+var BookLanguages = []string{"simple", "danish", "dutch", "english", "finnish", "french", "german", "italian", "norwegian", "portuguese", "russian", "spanish", "swedish"}
+
+// DefaultBookLanguage is the language of books created without one.
+const DefaultBookLanguage = "simple"
+
 type BookModel struct {
 	DB *sql.DB
 }
 
 func (b BookModel) Insert(book *Book) error {
 	query := `
-INSERT INTO books (title, author, year, genres, released_at)
-VALUES ($1, $2, $3, $4, $5)
-RETURNING id, created_at`
+INSERT INTO books (title, author, year, genres, released_at, language)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at, updated_at, version`
 
-	args := []any{book.Title, book.Author, book.Year, pq.Array(book.Genres), book.ReleasedAt}
+	args := []any{book.Title, book.Author, book.Year, pq.Array(book.Genres), book.ReleasedAt, book.Language}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return b.DB.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.CreatedAt)
+	return b.DB.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.CreatedAt, &book.UpdatedAt, &book.Version)
+
+}
+
+// InsertBatch inserts books in a single transaction, so either all of them are saved or none
+// are.
+func (b BookModel) InsertBatch(books []*Book) error {
+	query := `
+INSERT INTO books (title, author, year, genres, released_at, language)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, book := range books {
+		args := []any{book.Title, book.Author, book.Year, pq.Array(book.Genres), book.ReleasedAt, book.Language}
+		err = stmt.QueryRowContext(ctx, args...).Scan(&book.ID, &book.CreatedAt, &book.UpdatedAt, &book.Version)
+		if err != nil {
+			return err
+		}
+	}
 
+	return tx.Commit()
 }
+
 func (b BookModel) Get(id int64) (*Book, error) {
 	if id < 1 {
-		return nil, nil
+		return nil, ErrRecordNotFound
 	}
 
 	query := `
-SELECT id, created_at, title, author,  year, genres, released_at
+SELECT id, created_at, title, author,  year, genres, released_at, language, updated_at, version
 FROM books
 WHERE id = $1`
 
@@ -61,6 +109,9 @@ WHERE id = $1`
 		&book.Year,
 		pq.Array(&book.Genres),
 		&book.ReleasedAt,
+		&book.Language,
+		&book.UpdatedAt,
+		&book.Version,
 	)
 
 	if err != nil {
@@ -75,12 +126,14 @@ WHERE id = $1`
 	return &book, nil
 
 }
+
+// Update saves the book, as long as nobody else has changed it since it was read.
 func (b BookModel) Update(book *Book) error {
 	query := `
 UPDATE books
-SET title = $1, author = $2, year = $3, genres = $4, released_at = $6
-WHERE id = $5
-RETURNING released_at`
+SET title = $1, author = $2, year = $3, genres = $4, released_at = $6, language = $8, updated_at = NOW(), version = version + 1
+WHERE id = $5 AND version = $7
+RETURNING updated_at, version`
 	args := []interface{}{
 		book.Title,
 		book.Author,
@@ -88,11 +141,13 @@ RETURNING released_at`
 		pq.Array(book.Genres),
 		book.ID,
 		book.ReleasedAt,
+		book.Version,
+		book.Language,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&book.ReleasedAt)
+	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&book.UpdatedAt, &book.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -104,18 +159,20 @@ RETURNING released_at`
 	return nil
 }
 
-func (b BookModel) Delete(id int64) error {
+// Delete removes a book. A version other than zero only deletes the book if it is still at
+// that version, and otherwise returns ErrEditConflict.
+func (b BookModel) Delete(id int64, version int32) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
 DELETE FROM books
-WHERE id = $1`
+WHERE id = $1 AND (version = $2 OR $2 = 0)`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := b.DB.ExecContext(ctx, query, id)
+	result, err := b.DB.ExecContext(ctx, query, id, version)
 	if err != nil {
 		return err
 	}
@@ -126,6 +183,9 @@ WHERE id = $1`
 	}
 
 	if rowsAffected == 0 {
+		if version != 0 {
+			return ErrEditConflict
+		}
 		return ErrRecordNotFound
 	}
 	return nil
@@ -139,25 +199,262 @@ func ValidateBook(v *validator.Validator, book *Book) {
 	v.Check(book.ReleasedAt != 0, "released_at", "must be provided")
 	v.Check(len(book.Genres) > 0, "genres", "must be provided")
 	v.Check(book.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+	v.Check(validator.In(book.Language, BookLanguages...), "language", "must be a supported language")
+}
+
+// BookSearch holds the conditions books are listed and exported by; zero values leave a
+// condition out. A BranchID keeps only books with at least one copy at that branch. Query is
+// a web search style query (quoted phrases, "or", and "-" to exclude a word) run against the
+// title, author and genres, and parsed with the Language text search configuration.
+//
+// Genres keeps books with all of the genres, and GenresAny those with at least one of them.
+// Authors keeps books by any of the authors, ignoring case. The year and released ranges
+// include both ends.
+//
+// With Fuzzy set, Title and Author match any title or author containing a run of words with
+// a trigram word similarity of at least Similarity to them, which forgives typos.
+type BookSearch struct {
+	Title        string
+	Author       string
+	Authors      []string
+	Genres       []string
+	GenresAny    []string
+	YearMin      int32
+	YearMax      int32
+	ReleasedMin  int32
+	ReleasedMax  int32
+	CreatedAfter time.Time
+	BranchID     int64
+	Query        string
+	Language     string
+	Fuzzy        bool
+	Similarity   float64
+}
+
+// DefaultBookSimilarity is the Similarity fuzzy searches use unless told otherwise. It is
+// lower than pg_trgm's own default of 0.6, which misses most misspelt names.
+const DefaultBookSimilarity = 0.4
+
+// Ranked reports whether the search gives books a relevance to sort by.
+func (s BookSearch) Ranked() bool {
+	return s.Query != "" || (s.Fuzzy && (s.Title != "" || s.Author != ""))
+}
+
+func (s BookSearch) language() string {
+	if s.Language == "" {
+		return DefaultBookLanguage
+	}
+	return s.Language
+}
+
+// conditions returns the search as conditions on the books table.
+func (s BookSearch) conditions() *conditions {
+	c := &conditions{}
+
+	// The <% operator, unlike word_similarity(), can use the trigram indexes. Its threshold
+	// is set by configure.
+	if s.Title != "" {
+		if s.Fuzzy {
+			c.and("%s <%% title", s.Title)
+		} else {
+			c.and("to_tsvector('simple', title) @@ plainto_tsquery('simple', %s)", s.Title)
+		}
+	}
+	if s.Author != "" {
+		if s.Fuzzy {
+			c.and("%s <%% author", s.Author)
+		} else {
+			c.and("to_tsvector('simple', author) @@ plainto_tsquery('simple', %s)", s.Author)
+		}
+	}
+	if len(s.Authors) > 0 {
+		patterns := make([]string, len(s.Authors))
+		for i, author := range s.Authors {
+			patterns[i] = likeEscaper.Replace(author)
+		}
+		c.and("author ILIKE ANY(%s)", pq.Array(patterns))
+	}
+	if len(s.Genres) > 0 {
+		c.and("genres @> %s", pq.Array(s.Genres))
+	}
+	if len(s.GenresAny) > 0 {
+		c.and("genres && %s", pq.Array(s.GenresAny))
+	}
+	if s.YearMin != 0 {
+		c.and("year >= %s", s.YearMin)
+	}
+	if s.YearMax != 0 {
+		c.and("year <= %s", s.YearMax)
+	}
+	if s.ReleasedMin != 0 {
+		c.and("released_at >= %s", s.ReleasedMin)
+	}
+	if s.ReleasedMax != 0 {
+		c.and("released_at <= %s", s.ReleasedMax)
+	}
+	if !s.CreatedAfter.IsZero() {
+		c.and("created_at > %s", s.CreatedAfter)
+	}
+	if s.BranchID != 0 {
+		c.and("EXISTS (SELECT 1 FROM copies WHERE copies.book_id = books.id AND copies.branch_id = %s)", s.BranchID)
+	}
+	if s.Query != "" {
+		c.and("search @@ websearch_to_tsquery(%s::regconfig, %s)", s.language(), s.Query)
+	}
+
+	return c
+}
+
+// configure prepares tx for running the search's queries.
+func (s BookSearch) configure(ctx context.Context, tx *sql.Tx) error {
+	if !s.Fuzzy {
+		return nil
+	}
+
+	similarity := s.Similarity
+	if similarity == 0 {
+		similarity = DefaultBookSimilarity
+	}
+
+	_, err := tx.ExecContext(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`, strconv.FormatFloat(similarity, 'f', -1, 64))
+	return err
+}
+
+// queryer runs queries, on a database or in a transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// open returns where the search's queries should run. Fuzzy searches need a setting of their
+// own, so they get a read-only transaction with it applied; everything else runs on db, and
+// doesn't pay for a transaction. done must be called once the queries are finished.
+func (s BookSearch) open(ctx context.Context, db *sql.DB) (q queryer, done func(), err error) {
+	if !s.Fuzzy {
+		return db, func() {}, nil
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.configure(ctx, tx); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+	return tx, func() { tx.Rollback() }, nil
 }
 
-func (b BookModel) GetAll(title string, author string, genres []string, filters Filters) ([]*Book, Metadata, error) {
+// relevance returns an expression ranking books by how well they match the search's Query
+// and, for fuzzy searches, by how close their title and author are to the ones searched for.
+// It is what the "relevance" sort orders by, and is zero for every book when the search isn't
+// Ranked.
+func (s BookSearch) relevance(c *conditions) string {
+	var terms []string
+	if s.Query != "" {
+		terms = append(terms, fmt.Sprintf("ts_rank(search, websearch_to_tsquery(%s::regconfig, %s))", c.arg(s.language()), c.arg(s.Query)))
+	}
+	if s.Fuzzy && s.Title != "" {
+		terms = append(terms, fmt.Sprintf("word_similarity(%s, title)", c.arg(s.Title)))
+	}
+	if s.Fuzzy && s.Author != "" {
+		terms = append(terms, fmt.Sprintf("word_similarity(%s, author)", c.arg(s.Author)))
+	}
+	if len(terms) == 0 {
+		return "0"
+	}
+	return strings.Join(terms, " + ")
+}
+
+// headline returns an expression for the book's title and author with the words matching the
+// search's Query wrapped in <b> tags, or an empty string without a Query. The title and author
+// are HTML-escaped first, so the tags are the only markup in a headline and it can be shown
+// as HTML.
+func (s BookSearch) headline(c *conditions) string {
+	if s.Query == "" {
+		return "''"
+	}
+	language := c.arg(s.language())
+	return fmt.Sprintf("ts_headline(%s::regconfig, %s, websearch_to_tsquery(%s::regconfig, %s), 'HighlightAll=true')", language, htmlEscape("title || ' / ' || author"), language, c.arg(s.Query))
+}
+
+// htmlEscape returns an SQL expression escaping the HTML special characters in expr.
+func htmlEscape(expr string) string {
+	for _, r := range [][2]string{{"&", "&amp;"}, {"<", "&lt;"}, {">", "&gt;"}, {`"`, "&quot;"}, {"'", "&#39;"}} {
+		expr = fmt.Sprintf("replace(%s, %s, %s)", expr, quoteLiteral(r[0]), quoteLiteral(r[1]))
+	}
+	return expr
+}
+
+// quoteLiteral quotes s as an SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// GetAll lists a page of the books matching the search. Pages are picked by number, or with
+// the filters' After or Before cursor. Cursor pages skip counting every match, so their
+// Metadata only has the page size and the cursors of the neighbouring pages.
+func (b BookModel) GetAll(search BookSearch, filters Filters) ([]*Book, Metadata, error) {
+	c := search.conditions()
+
+	column, direction := filters.sortColumn(), filters.sortDirection()
+
+	count, limit, order := "count(*) OVER()", "", fmt.Sprintf("%s %s, id ASC", column, direction)
+	if !filters.keyset() {
+		limit = fmt.Sprintf("LIMIT %s OFFSET %s", c.arg(filters.limit()), c.arg(filters.offset()))
+	} else {
+		cur, err := filters.cursor()
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		key, err := bookSortKey(column, cur.Key)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		// Rows past the cursor come after it in the sort order, or tie with it and have a
+		// greater id. Before a cursor, the order is reversed to find the rows closest to it,
+		// and put back the right way round below.
+		sortExpr := column
+		if column == "relevance" {
+			sortExpr = "(" + search.relevance(c) + ")"
+		}
+		after, tie := ">", ">"
+		if direction == "DESC" {
+			after = "<"
+		}
+		if filters.Before != "" {
+			after, tie = flipComparison(after), "<"
+			order = fmt.Sprintf("%s %s, id DESC", column, flipDirection(direction))
+		}
+
+		k, id := c.arg(key), c.arg(cur.ID)
+		c.add(fmt.Sprintf("%s %s %s OR (%s = %s AND id %s %s)", sortExpr, after, k, sortExpr, k, tie, id))
 
+		// One more row than needed shows whether there's a page beyond this one.
+		count, limit = "0", "LIMIT "+c.arg(filters.limit()+1)
+	}
+
+	// Headlines are worked out in an outer query, so only the books on the page get one.
 	query := fmt.Sprintf(`
-SELECT count(*) OVER(), id, created_at, title,author, year, genres, released_at
-FROM books
-WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') AND 
-      (to_tsvector('simple', author) @@ plainto_tsquery('simple', $2) OR $2 = '')
-AND (genres @> $3 OR $3 = '{}')
-ORDER BY %s %s, id ASC
-LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+SELECT total, id, created_at, title, author, year, genres, released_at, language, %s, updated_at, version, relevance
+FROM (
+    SELECT %s AS total, id, created_at, title, author, year, genres, released_at, language, updated_at, version, %s AS relevance
+    FROM books%s
+    ORDER BY %s
+    %s
+) AS page
+ORDER BY %s`, search.headline(c), count, search.relevance(c), c.where(), order, limit, order)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{title, author, pq.Array(genres), filters.limit(), filters.offset()}
+	q, done, err := search.open(ctx, b.DB)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer done()
 
-	rows, err := b.DB.QueryContext(ctx, query, args...)
+	rows, err := q.QueryContext(ctx, query, c.args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -166,32 +463,340 @@ LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
 
 	totalRecords := 0
 	books := []*Book{}
+	cursors := []cursor{}
 
 	for rows.Next() {
 
 		var book Book
+		var relevance float32
 
 		err := rows.Scan(
 			&totalRecords,
 			&book.ID,
 			&book.CreatedAt,
 			&book.Title,
+			&book.Author,
 			&book.Year,
 			pq.Array(&book.Genres),
 			&book.ReleasedAt,
+			&book.Language,
+			&book.Headline,
+			&book.UpdatedAt,
+			&book.Version,
+			&relevance,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
 		books = append(books, &book)
+		cursors = append(cursors, cursor{Sort: filters.Sort, Key: formatBookSortKey(column, &book, relevance), ID: book.ID})
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	if !filters.keyset() {
+		metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+		if len(books) > 0 {
+			if filters.Page > 1 {
+				metadata.PrevCursor = cursors[0].encode()
+			}
+			if filters.Page < metadata.LastPage {
+				metadata.NextCursor = cursors[len(cursors)-1].encode()
+			}
+		}
+		return books, metadata, nil
+	}
+
+	more := len(books) > filters.limit()
+	if more {
+		books, cursors = books[:filters.limit()], cursors[:filters.limit()]
+	}
+	if filters.Before != "" {
+		for i, j := 0, len(books)-1; i < j; i, j = i+1, j-1 {
+			books[i], books[j] = books[j], books[i]
+			cursors[i], cursors[j] = cursors[j], cursors[i]
+		}
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+	if len(books) > 0 {
+		// The cursor itself marks a page on its far side.
+		first, last := cursors[0].encode(), cursors[len(cursors)-1].encode()
+		if filters.After != "" {
+			metadata.PrevCursor = first
+			if more {
+				metadata.NextCursor = last
+			}
+		} else {
+			metadata.NextCursor = last
+			if more {
+				metadata.PrevCursor = first
+			}
+		}
+	}
+	return books, metadata, nil
+}
+
+// bookSortKey parses a cursor's key for the sort column it was made for.
+func bookSortKey(column, key string) (any, error) {
+	var value any
+	var err error
+	switch column {
+	case "title":
+		value = key
+	case "year":
+		value, err = strconv.ParseInt(key, 10, 32)
+	case "relevance":
+		value, err = strconv.ParseFloat(key, 32)
+	default:
+		value, err = strconv.ParseInt(key, 10, 64)
+	}
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return value, nil
+}
+
+// formatBookSortKey returns the value a book was sorted by, for its cursor.
+func formatBookSortKey(column string, book *Book, relevance float32) string {
+	switch column {
+	case "title":
+		return book.Title
+	case "year":
+		return strconv.FormatInt(int64(book.Year), 10)
+	case "relevance":
+		return strconv.FormatFloat(float64(relevance), 'g', -1, 32)
+	default:
+		return strconv.FormatInt(book.ID, 10)
+	}
+}
+
+// BookFacets are the facets GetFacets can count books by. Years are counted by decade, and
+// availability by whether a copy is available, at the search's branch if it has one.
+var BookFacets = []string{"genres", "author", "year", "availability"}
+
+// facetLimit is the most values returned for a facet. Values are ordered by how many books
+// they have, so the least common are the ones left out.
+const facetLimit = 20
+
+// bookFacetQueries return, for each facet, a query counting the books matching a search by
+// the facet's values.
+var bookFacetQueries = map[string]func(s BookSearch, c *conditions) string{
+	"genres": func(s BookSearch, c *conditions) string {
+		return fmt.Sprintf(`
+SELECT genre, count(*)
+FROM books CROSS JOIN LATERAL unnest(genres) AS genre%s
+GROUP BY genre
+ORDER BY count(*) DESC, genre
+LIMIT %s`, c.where(), c.arg(facetLimit))
+	},
+	"author": func(s BookSearch, c *conditions) string {
+		return fmt.Sprintf(`
+SELECT author, count(*)
+FROM books%s
+GROUP BY author
+ORDER BY count(*) DESC, author
+LIMIT %s`, c.where(), c.arg(facetLimit))
+	},
+	"year": func(s BookSearch, c *conditions) string {
+		return fmt.Sprintf(`
+SELECT decade::text || '-' || (decade + 9)::text, count(*)
+FROM (SELECT year / 10 * 10 AS decade FROM books%s) AS decades
+GROUP BY decade
+ORDER BY count(*) DESC, decade DESC
+LIMIT %s`, c.where(), c.arg(facetLimit))
+	},
+	"availability": func(s BookSearch, c *conditions) string {
+		branch := ""
+		if s.BranchID != 0 {
+			branch = " AND copies.branch_id = " + c.arg(s.BranchID)
+		}
+		return fmt.Sprintf(`
+SELECT CASE WHEN available THEN 'available' ELSE 'unavailable' END, count(*)
+FROM (
+    SELECT EXISTS (
+        SELECT 1
+        FROM copies
+        WHERE copies.book_id = books.id AND copies.status = 'available'%s
+    ) AS available
+    FROM books%s
+) AS availability
+GROUP BY available
+ORDER BY available DESC
+LIMIT %s`, branch, c.where(), c.arg(facetLimit))
+	},
+}
+
+// GetFacets counts the books matching the search by the values of each of the named facets,
+// which must be in BookFacets.
+func (b BookModel) GetFacets(search BookSearch, names []string) (map[string][]Facet, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	q, done, err := search.open(ctx, b.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	facets := make(map[string][]Facet, len(names))
+	for _, name := range names {
+		facetQuery, ok := bookFacetQueries[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown book facet %q", name)
+		}
+
+		c := search.conditions()
+		query := facetQuery(search, c)
+
+		rows, err := q.QueryContext(ctx, query, c.args...)
+		if err != nil {
+			return nil, err
+		}
+
+		values := []Facet{}
+		for rows.Next() {
+			var facet Facet
+			if err := rows.Scan(&facet.Value, &facet.Count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			values = append(values, facet)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		facets[name] = values
+	}
+	return facets, nil
+}
+
+// exportFetchSize is how many rows Export fetches from its cursor at a time.
+const exportFetchSize = 500
+
+// Export calls fn with every book matching the search, in the order given by filters. The
+// books are read through a server-side cursor, so only exportFetchSize of them are held in
+// memory at once. Export stops at the first error from fn, and returns it.
+func (b BookModel) Export(ctx context.Context, search BookSearch, filters Filters, fn func(*Book) error) error {
+	c := search.conditions()
+
+	query := fmt.Sprintf(`
+DECLARE books_export NO SCROLL CURSOR FOR
+SELECT id, created_at, title, author, year, genres, released_at, language, updated_at, version, %s AS relevance
+FROM books%s
+ORDER BY %s %s, id ASC`, search.relevance(c), c.where(), filters.sortColumn(), filters.sortDirection())
+
+	// The snapshot keeps the export consistent however long it takes.
+	tx, err := b.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := search.configure(ctx, tx); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, c.args...)
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM books_export", exportFetchSize)
+	for {
+		rows, err := tx.QueryContext(ctx, fetch)
+		if err != nil {
+			return err
+		}
 
-	return books, metadata, err
+		n := 0
+		for rows.Next() {
+			var book Book
+			var relevance float32
+			err := rows.Scan(
+				&book.ID,
+				&book.CreatedAt,
+				&book.Title,
+				&book.Author,
+				&book.Year,
+				pq.Array(&book.Genres),
+				&book.ReleasedAt,
+				&book.Language,
+				&book.UpdatedAt,
+				&book.Version,
+				&relevance,
+			)
+			if err == nil {
+				err = fn(&book)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			n++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		if n < exportFetchSize {
+			return nil
+		}
+	}
+}
+
+// BookSuggestion is a title or author offered while a patron types a search.
+type BookSuggestion struct {
+	Value string `json:"value"`
+	Field string `json:"field"`
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Suggest returns up to limit distinct titles and authors with a word starting with prefix,
+// ignoring case. Those starting with prefix come first, followed by the closest matches.
+func (b BookModel) Suggest(prefix string, limit int) ([]*BookSuggestion, error) {
+	query := `
+SELECT value, field
+FROM (
+    SELECT title AS value, 'title' AS field
+    FROM books
+    WHERE title ILIKE $1 || '%' OR title ILIKE '% ' || $1 || '%'
+    UNION
+    SELECT author, 'author'
+    FROM books
+    WHERE author ILIKE $1 || '%' OR author ILIKE '% ' || $1 || '%'
+) AS matches
+ORDER BY value ILIKE $1 || '%' DESC, similarity(value, $2) DESC, value, field
+LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := b.DB.QueryContext(ctx, query, likeEscaper.Replace(prefix), prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*BookSuggestion{}
+	for rows.Next() {
+		var suggestion BookSuggestion
+		if err := rows.Scan(&suggestion.Value, &suggestion.Field); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
 }
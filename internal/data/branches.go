@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Eldiai/go_library/internal/validator"
+)
+
+var (
+	ErrDuplicateBranchName = errors.New("duplicate branch name")
+)
+
+type Branch struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address,omitempty"`
+	Version   int32     `json:"-"`
+}
+
+type BranchModel struct {
+	DB *sql.DB
+}
+
+func ValidateBranch(v *validator.Validator, branch *Branch) {
+	v.Check(branch.Name != "", "name", "must be provided")
+	v.Check(len(branch.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(branch.Address) <= 500, "address", "must not be more than 500 bytes long")
+}
+
+func (m BranchModel) Insert(branch *Branch) error {
+	query := `
+INSERT INTO branches (name, address)
+VALUES ($1, $2)
+RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, branch.Name, branch.Address).Scan(&branch.ID, &branch.CreatedAt, &branch.Version)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), `duplicate key value violates unique constraint "branches_name_key"`):
+			return ErrDuplicateBranchName
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m BranchModel) Get(id int64) (*Branch, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, name, address, version
+FROM branches
+WHERE id = $1`
+
+	var branch Branch
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&branch.ID,
+		&branch.CreatedAt,
+		&branch.Name,
+		&branch.Address,
+		&branch.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &branch, nil
+}
+
+func (m BranchModel) GetAll() ([]*Branch, error) {
+	query := `
+SELECT id, created_at, name, address, version
+FROM branches
+ORDER BY name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	branches := []*Branch{}
+	for rows.Next() {
+		var branch Branch
+		err := rows.Scan(
+			&branch.ID,
+			&branch.CreatedAt,
+			&branch.Name,
+			&branch.Address,
+			&branch.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, &branch)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return branches, nil
+}
+
+func (m BranchModel) Update(branch *Branch) error {
+	query := `
+UPDATE branches
+SET name = $1, address = $2, version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+
+	args := []any{branch.Name, branch.Address, branch.ID, branch.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&branch.Version)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), `duplicate key value violates unique constraint "branches_name_key"`):
+			return ErrDuplicateBranchName
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m BranchModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+DELETE FROM branches
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
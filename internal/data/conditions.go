@@ -0,0 +1,47 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// conditions builds the WHERE clause of a query one condition at a time. Values are always
+// passed as numbered parameters, never written into the SQL, and only the conditions that
+// are added take part, so optional filters don't need to be switched off in SQL.
+type conditions struct {
+	clauses []string
+	args    []any
+}
+
+// arg adds a parameter with the given value, and returns its placeholder. It is also how
+// values used outside the WHERE clause, such as in the select list or LIMIT, get their
+// placeholders, so that all of a query's parameters are numbered together.
+func (c *conditions) arg(value any) string {
+	c.args = append(c.args, value)
+	return "$" + strconv.Itoa(len(c.args))
+}
+
+// and adds a condition. Each %s in format stands for the placeholder of the matching value
+// in args, and a literal % must be written as %%.
+func (c *conditions) and(format string, args ...any) {
+	placeholders := make([]any, len(args))
+	for i, value := range args {
+		placeholders[i] = c.arg(value)
+	}
+	c.add(fmt.Sprintf(format, placeholders...))
+}
+
+// add adds a condition whose placeholders have already been taken from arg.
+func (c *conditions) add(clause string) {
+	c.clauses = append(c.clauses, "("+clause+")")
+}
+
+// where returns the WHERE clause, starting on a new line, or nothing if there are no
+// conditions.
+func (c *conditions) where() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return "\nWHERE " + strings.Join(c.clauses, "\nAND ")
+}
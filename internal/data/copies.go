@@ -0,0 +1,334 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Eldiai/go_library/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateBarcode  = errors.New("duplicate barcode")
+	ErrCopyInCirculation = errors.New("copy in circulation")
+)
+
+const (
+	CopyStatusAvailable   = "available"
+	CopyStatusLost        = "lost"
+	CopyStatusMaintenance = "maintenance"
+	CopyStatusOnLoan      = "on_loan"
+	CopyStatusOnHold      = "on_hold"
+	CopyStatusInTransit   = "in_transit"
+)
+
+var (
+	CopyConditions = []string{"new", "good", "fair", "poor", "damaged"}
+	// CopyItemTypes must each have a row in the fine_rules table.
+	CopyItemTypes = []string{"book", "audiobook", "magazine", "dvd"}
+	CopyStatuses  = []string{CopyStatusAvailable, CopyStatusLost, CopyStatusMaintenance, CopyStatusOnLoan, CopyStatusOnHold, CopyStatusInTransit}
+	// CopyShelfStatuses are the statuses staff may set by hand. The rest are managed by
+	// the circulation (loan) code.
+	CopyShelfStatuses = []string{CopyStatusAvailable, CopyStatusLost, CopyStatusMaintenance}
+)
+
+// Copy is a single physical item of a Book, identified by its barcode.
+type Copy struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	BookID    int64     `json:"book_id"`
+	BranchID  *int64    `json:"branch_id,omitempty"`
+	Barcode   string    `json:"barcode"`
+	ItemType  string    `json:"item_type"`
+	Condition string    `json:"condition"`
+	Location  string    `json:"location"`
+	Status    string    `json:"status"`
+	Version   int32     `json:"-"`
+}
+
+// Availability holds the number of copies a book has and how many of them can be lent out
+// right now.
+type Availability struct {
+	Total     int `json:"total"`
+	Available int `json:"available"`
+}
+
+type CopyModel struct {
+	DB *sql.DB
+}
+
+func ValidateCopy(v *validator.Validator, c *Copy) {
+	v.Check(c.Barcode != "", "barcode", "must be provided")
+	v.Check(len(c.Barcode) <= 64, "barcode", "must not be more than 64 bytes long")
+	v.Check(validator.In(c.ItemType, CopyItemTypes...), "item_type", "invalid item type value")
+	v.Check(validator.In(c.Condition, CopyConditions...), "condition", "invalid condition value")
+	v.Check(c.Location != "", "location", "must be provided")
+	v.Check(len(c.Location) <= 200, "location", "must not be more than 200 bytes long")
+	v.Check(validator.In(c.Status, CopyStatuses...), "status", "invalid status value")
+}
+
+// Insert adds a copy. A copy added as available goes to the first waiting hold on the book,
+// like a returned one, and that hold is returned so the patron can be notified.
+func (m CopyModel) Insert(c *Copy) (*Hold, error) {
+	query := `
+INSERT INTO copies (book_id, branch_id, barcode, item_type, condition, location, status)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, created_at, version`
+
+	args := []any{c.BookID, c.BranchID, c.Barcode, c.ItemType, c.Condition, c.Location, c.Status}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockBook(ctx, tx, c.BookID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&c.ID, &c.CreatedAt, &c.Version)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), `duplicate key value violates unique constraint "copies_barcode_key"`):
+			return nil, ErrDuplicateBarcode
+		default:
+			return nil, err
+		}
+	}
+
+	hold, err := shelveCopy(ctx, tx, c)
+	if err != nil {
+		return nil, err
+	}
+	return hold, tx.Commit()
+}
+
+func (m CopyModel) Get(id int64) (*Copy, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, book_id, branch_id, barcode, item_type, condition, location, status, version
+FROM copies
+WHERE id = $1`
+
+	var c Copy
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&c.ID,
+		&c.CreatedAt,
+		&c.BookID,
+		&c.BranchID,
+		&c.Barcode,
+		&c.ItemType,
+		&c.Condition,
+		&c.Location,
+		&c.Status,
+		&c.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+func (m CopyModel) GetAllForBook(bookID int64) ([]*Copy, error) {
+	query := `
+SELECT id, created_at, book_id, branch_id, barcode, item_type, condition, location, status, version
+FROM copies
+WHERE book_id = $1
+ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	copies := []*Copy{}
+	for rows.Next() {
+		var c Copy
+		err := rows.Scan(
+			&c.ID,
+			&c.CreatedAt,
+			&c.BookID,
+			&c.BranchID,
+			&c.Barcode,
+			&c.ItemType,
+			&c.Condition,
+			&c.Location,
+			&c.Status,
+			&c.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return copies, nil
+}
+
+// Update saves changes to a copy. A copy which is available afterwards, such as one staff
+// have put back on the shelf, goes to the first waiting hold on the book like a returned one,
+// and that hold is returned so the patron can be notified.
+func (m CopyModel) Update(c *Copy) (*Hold, error) {
+	query := `
+UPDATE copies
+SET branch_id = $1, barcode = $2, item_type = $3, condition = $4, location = $5, status = $6, version = version + 1
+WHERE id = $7 AND version = $8
+RETURNING version`
+
+	args := []any{
+		c.BranchID,
+		c.Barcode,
+		c.ItemType,
+		c.Condition,
+		c.Location,
+		c.Status,
+		c.ID,
+		c.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockBook(ctx, tx, c.BookID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&c.Version)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), `duplicate key value violates unique constraint "copies_barcode_key"`):
+			return nil, ErrDuplicateBarcode
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	hold, err := shelveCopy(ctx, tx, c)
+	if err != nil {
+		return nil, err
+	}
+	return hold, tx.Commit()
+}
+
+// shelveCopy hands an available copy to the first waiting hold on its book, and reloads the
+// copy's status and version in case that changed them. The book must already be locked by the
+// transaction.
+func shelveCopy(ctx context.Context, tx *sql.Tx, c *Copy) (*Hold, error) {
+	if c.Status != CopyStatusAvailable {
+		return nil, nil
+	}
+
+	hold, err := fillNextHold(ctx, tx, c.BookID, c.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT status, version FROM copies WHERE id = $1`, c.ID).Scan(&c.Status, &c.Version)
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// Delete removes a copy, as long as it is on the shelf. A copy on loan, set aside for a hold
+// or in transit is refused with ErrCopyInCirculation, since deleting it would take its loan,
+// hold or transfer with it. The status is checked by the DELETE itself, so a checkout can't
+// slip in between.
+func (m CopyModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+DELETE FROM copies
+WHERE id = $1 AND status = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, pq.Array(CopyShelfStatuses))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		var exists bool
+		err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM copies WHERE id = $1)`, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrCopyInCirculation
+		}
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAvailability counts the copies of a book, and how many of them are on the shelf.
+func (m CopyModel) GetAvailability(bookID int64) (Availability, error) {
+	query := `
+SELECT count(*), count(*) FILTER (WHERE status = $2)
+FROM copies
+WHERE book_id = $1`
+
+	var availability Availability
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, bookID, CopyStatusAvailable).Scan(
+		&availability.Total,
+		&availability.Available,
+	)
+	return availability, err
+}
+
+// setCopyStatus changes the circulation status of a copy as part of a larger transaction.
+func setCopyStatus(ctx context.Context, tx *sql.Tx, copyID int64, status string) error {
+	query := `
+UPDATE copies
+SET status = $1, version = version + 1
+WHERE id = $2`
+
+	_, err := tx.ExecContext(ctx, query, status, copyID)
+	return err
+}
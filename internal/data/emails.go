@@ -0,0 +1,272 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	EmailStatusPending = "pending"
+	EmailStatusSent    = "sent"
+	EmailStatusDead    = "dead"
+)
+
+const (
+	// emailLease is how long a claimed email is hidden from other workers while it is
+	// delivered.
+	emailLease = 5 * time.Minute
+	// maxEmailBackoff caps the wait between delivery attempts, however many there have been.
+	maxEmailBackoff = 24 * time.Hour
+)
+
+// Email is a message in the outbox. Bodies can carry plaintext tokens, which are only ever
+// stored hashed elsewhere, so they are cleared once the email is sent, and settled emails are
+// deleted altogether by DeleteSettled.
+type Email struct {
+	ID            int64      `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Recipient     string     `json:"recipient"`
+	Subject       string     `json:"subject"`
+	PlainBody     string     `json:"-"`
+	HTMLBody      string     `json:"-"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+type EmailModel struct {
+	DB *sql.DB
+}
+
+func (m EmailModel) Insert(email *Email) error {
+	query := `
+INSERT INTO emails (recipient, subject, plain_body, html_body)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, status, attempts, next_attempt_at`
+
+	args := []any{email.Recipient, email.Subject, email.PlainBody, email.HTMLBody}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&email.ID,
+		&email.CreatedAt,
+		&email.Status,
+		&email.Attempts,
+		&email.NextAttemptAt,
+	)
+}
+
+// ClaimDue returns up to limit pending emails that are due for delivery. Claimed emails are
+// pushed back by a short lease, so that workers on other instances skip them; MarkSent or
+// MarkFailed settle them for good.
+func (m EmailModel) ClaimDue(ctx context.Context, limit int) ([]*Email, error) {
+	query := `
+UPDATE emails
+SET next_attempt_at = $1
+WHERE id IN (
+    SELECT id
+    FROM emails
+    WHERE status = $2 AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at, id
+    LIMIT $3
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, created_at, recipient, subject, plain_body, html_body, status, attempts, next_attempt_at, last_error, sent_at`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now().Add(emailLease), EmailStatusPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*Email{}
+	for rows.Next() {
+		var email Email
+		err := rows.Scan(
+			&email.ID,
+			&email.CreatedAt,
+			&email.Recipient,
+			&email.Subject,
+			&email.PlainBody,
+			&email.HTMLBody,
+			&email.Status,
+			&email.Attempts,
+			&email.NextAttemptAt,
+			&email.LastError,
+			&email.SentAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, &email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
+
+// GetAllByStatus lists emails with the given status, most recent first.
+func (m EmailModel) GetAllByStatus(status string, limit int) ([]*Email, error) {
+	query := `
+SELECT id, created_at, recipient, subject, status, attempts, next_attempt_at, last_error, sent_at
+FROM emails
+WHERE status = $1
+ORDER BY id DESC
+LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*Email{}
+	for rows.Next() {
+		var email Email
+		err := rows.Scan(
+			&email.ID,
+			&email.CreatedAt,
+			&email.Recipient,
+			&email.Subject,
+			&email.Status,
+			&email.Attempts,
+			&email.NextAttemptAt,
+			&email.LastError,
+			&email.SentAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, &email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
+
+// MarkSent records a delivered email, and clears its bodies since they won't be needed again.
+func (m EmailModel) MarkSent(email *Email) error {
+	query := `
+UPDATE emails
+SET status = $1, attempts = attempts + 1, sent_at = NOW(), last_error = '', plain_body = '', html_body = ''
+WHERE id = $2
+RETURNING status, attempts, sent_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, EmailStatusSent, email.ID).Scan(&email.Status, &email.Attempts, &email.SentAt)
+	if err != nil {
+		return err
+	}
+	email.PlainBody, email.HTMLBody = "", ""
+	return nil
+}
+
+// RecordFailure updates the email for a failed delivery attempt. It is retried after backoff,
+// doubled for every earlier attempt up to a day, until maxAttempts is reached and the email
+// is dead.
+func (e *Email) RecordFailure(deliveryErr error, maxAttempts int, backoff time.Duration) {
+	e.Attempts++
+	e.LastError = deliveryErr.Error()
+	e.NextAttemptAt = time.Now().Add(emailBackoff(backoff, e.Attempts))
+	if e.Attempts >= maxAttempts {
+		e.Status = EmailStatusDead
+	}
+}
+
+// emailBackoff returns the wait after the given number of attempts. It doubles one step at a
+// time rather than shifting by the attempt count, which would overflow time.Duration.
+func emailBackoff(backoff time.Duration, attempts int) time.Duration {
+	delay := backoff
+	for i := 1; i < attempts && delay < maxEmailBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxEmailBackoff {
+		delay = maxEmailBackoff
+	}
+	return delay
+}
+
+// MarkFailed records a failed delivery attempt, as described by RecordFailure.
+func (m EmailModel) MarkFailed(email *Email, deliveryErr error, maxAttempts int, backoff time.Duration) error {
+	email.RecordFailure(deliveryErr, maxAttempts, backoff)
+
+	query := `
+UPDATE emails
+SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4
+WHERE id = $5`
+
+	args := []any{email.Status, email.Attempts, email.NextAttemptAt, email.LastError, email.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Requeue gives a dead email a fresh set of delivery attempts, starting now.
+func (m EmailModel) Requeue(id int64) (*Email, error) {
+	query := `
+UPDATE emails
+SET status = $1, attempts = 0, next_attempt_at = NOW(), last_error = ''
+WHERE id = $2 AND status = $3
+RETURNING id, created_at, recipient, subject, status, attempts, next_attempt_at, last_error, sent_at`
+
+	var email Email
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, EmailStatusPending, id, EmailStatusDead).Scan(
+		&email.ID,
+		&email.CreatedAt,
+		&email.Recipient,
+		&email.Subject,
+		&email.Status,
+		&email.Attempts,
+		&email.NextAttemptAt,
+		&email.LastError,
+		&email.SentAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &email, nil
+}
+
+// DeleteSettled deletes sent and dead emails created more than age ago, returning how many
+// there were. Dead emails keep their bodies so that they can be requeued until then.
+func (m EmailModel) DeleteSettled(ctx context.Context, age time.Duration) (int64, error) {
+	query := `
+DELETE FROM emails
+WHERE status IN ($1, $2) AND created_at < $3`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, EmailStatusSent, EmailStatusDead, time.Now().Add(-age))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
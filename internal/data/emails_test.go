@@ -0,0 +1,55 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEmailBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		backoff  time.Duration
+		attempts int
+		want     time.Duration
+	}{
+		{"first attempt", time.Minute, 1, time.Minute},
+		{"second attempt", time.Minute, 2, 2 * time.Minute},
+		{"fifth attempt", time.Minute, 5, 16 * time.Minute},
+		{"capped", time.Minute, 12, maxEmailBackoff},
+		{"would overflow a shift", time.Minute, 100, maxEmailBackoff},
+		{"backoff above the cap", 48 * time.Hour, 1, maxEmailBackoff},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := emailBackoff(tt.backoff, tt.attempts)
+			if got != tt.want {
+				t.Errorf("emailBackoff(%s, %d) = %s; want %s", tt.backoff, tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmailRecordFailure(t *testing.T) {
+	email := &Email{Status: EmailStatusPending}
+
+	for i := 1; i <= 3; i++ {
+		before := time.Now()
+		email.RecordFailure(errors.New("451 try again later"), 3, time.Minute)
+
+		if email.Attempts != i {
+			t.Fatalf("attempts = %d; want %d", email.Attempts, i)
+		}
+		if !email.NextAttemptAt.After(before) {
+			t.Errorf("attempt %d: next attempt %s is not after %s", i, email.NextAttemptAt, before)
+		}
+	}
+
+	if email.Status != EmailStatusDead {
+		t.Errorf("status = %q; want %q", email.Status, EmailStatusDead)
+	}
+	if email.LastError != "451 try again later" {
+		t.Errorf("last error = %q", email.LastError)
+	}
+}
@@ -1,16 +1,27 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"github.com/Eldiai/go_library/internal/validator"
 	"math"
 	"strings"
 )
 
+// ErrInvalidCursor is returned for an after or before cursor that wasn't handed out for the
+// list's current sort.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// After and Before are cursors from a Metadata's NextCursor or PrevCursor. Either one
+	// replaces Page, and lists the PageSize records following or preceding the cursor.
+	After  string
+	Before string
 }
 type Metadata struct {
 	CurrentPage  int `json:"current_page,omitempty"`
@@ -18,6 +29,17 @@ type Metadata struct {
 	FirstPage    int `json:"first_page,omitempty"`
 	LastPage     int `json:"last_page,omitempty"`
 	TotalRecords int `json:"total_records,omitempty"`
+
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+
+	Facets map[string][]Facet `json:"facets,omitempty"`
+}
+
+// Facet is the number of records sharing a value, out of those a list was filtered to.
+type Facet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
 }
 
 func (f Filters) sortColumn() string {
@@ -36,6 +58,69 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+// keyset reports whether the filters page by cursor rather than by page number.
+func (f Filters) keyset() bool {
+	return f.After != "" || f.Before != ""
+}
+
+// cursor is the position of a record in a sorted list: the value it was sorted by, and its
+// id, which breaks ties. Sort is kept so that a cursor can't be used with a different sort.
+type cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   int64  `json:"i"`
+}
+
+// encode returns the cursor as an opaque string, for clients to hand back unchanged.
+func (c cursor) encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(js, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// cursor decodes the filters' After or Before cursor, checking it was made for their sort.
+func (f Filters) cursor() (cursor, error) {
+	s := f.After
+	if s == "" {
+		s = f.Before
+	}
+
+	c, err := decodeCursor(s)
+	if err != nil {
+		return c, err
+	}
+	if c.Sort != f.Sort {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func flipDirection(direction string) string {
+	if direction == "DESC" {
+		return "ASC"
+	}
+	return "DESC"
+}
+
+func flipComparison(op string) string {
+	if op == ">" {
+		return "<"
+	}
+	return ">"
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -65,4 +150,15 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	v.Check(f.After == "" || f.Before == "", "after", "must not be used with before")
+	v.Check(!f.keyset() || f.Page == 1, "page", "must not be used with after or before")
+	if f.keyset() {
+		key := "after"
+		if f.Before != "" {
+			key = "before"
+		}
+		_, err := f.cursor()
+		v.Check(err == nil, key, "must be a cursor for the same sort from a previous page")
+	}
 }
@@ -0,0 +1,225 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Eldiai/go_library/internal/validator"
+)
+
+const (
+	FineKindCharge  = "charge"
+	FineKindPayment = "payment"
+	FineKindWaiver  = "waiver"
+)
+
+var (
+	ErrFineSettled = errors.New("fine already settled")
+)
+
+// overdueAmountSQL is the fine for a loan kept until the timestamp in %s: the daily rate of
+// the copy's item type for every started day past the due date, capped at the rule's maximum.
+// It expects loans and fine_rules in scope.
+const overdueAmountSQL = `LEAST(CEIL(EXTRACT(EPOCH FROM (%s - loans.due_at)) / 86400)::integer * fine_rules.daily_rate, fine_rules.max_amount)`
+
+// Fine is one entry in the fines ledger. Amounts are in cents: charges are positive, payments
+// and waivers negative. A waiver points at the charge it waives through ChargeID.
+type Fine struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UserID     int64     `json:"user_id"`
+	LoanID     *int64    `json:"loan_id,omitempty"`
+	ChargeID   *int64    `json:"charge_id,omitempty"`
+	Kind       string    `json:"kind"`
+	Amount     int64     `json:"amount"`
+	Note       string    `json:"note,omitempty"`
+	RecordedBy *int64    `json:"recorded_by,omitempty"`
+}
+
+// FineBalance is what a user owes. Ledger is the sum of the ledger entries, Accruing the
+// fines building up on loans that are still out past their due date.
+type FineBalance struct {
+	Ledger   int64 `json:"ledger"`
+	Accruing int64 `json:"accruing"`
+	Total    int64 `json:"total"`
+}
+
+type FineModel struct {
+	DB *sql.DB
+}
+
+func ValidateFineAmount(v *validator.Validator, amount int64) {
+	v.Check(amount > 0, "amount", "must be greater than zero")
+	v.Check(amount <= 1_000_000, "amount", "must be a maximum of 1000000")
+}
+
+func (m FineModel) GetAllForUser(userID int64) ([]*Fine, error) {
+	query := `
+SELECT id, created_at, user_id, loan_id, charge_id, kind, amount, note, recorded_by
+FROM fines
+WHERE user_id = $1
+ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fines := []*Fine{}
+	for rows.Next() {
+		var fine Fine
+		err := rows.Scan(
+			&fine.ID,
+			&fine.CreatedAt,
+			&fine.UserID,
+			&fine.LoanID,
+			&fine.ChargeID,
+			&fine.Kind,
+			&fine.Amount,
+			&fine.Note,
+			&fine.RecordedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+		fines = append(fines, &fine)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return fines, nil
+}
+
+func (m FineModel) Balance(userID int64) (FineBalance, error) {
+	query := `
+SELECT
+    (SELECT COALESCE(SUM(amount), 0) FROM fines WHERE user_id = $1),
+    (SELECT COALESCE(SUM(` + fmt.Sprintf(overdueAmountSQL, "NOW()") + `), 0)
+     FROM loans
+     INNER JOIN copies ON copies.id = loans.copy_id
+     INNER JOIN fine_rules ON fine_rules.item_type = copies.item_type
+     WHERE loans.user_id = $1 AND loans.returned_at IS NULL AND loans.due_at < NOW())`
+
+	var balance FineBalance
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&balance.Ledger, &balance.Accruing)
+	if err != nil {
+		return FineBalance{}, err
+	}
+	balance.Total = balance.Ledger + balance.Accruing
+	return balance, nil
+}
+
+// RecordPayment records money received from a user. amount is positive; it is stored as a
+// negative ledger entry.
+func (m FineModel) RecordPayment(userID, amount int64, note string, recordedBy int64) (*Fine, error) {
+	fine := &Fine{
+		UserID:     userID,
+		Kind:       FineKindPayment,
+		Amount:     -amount,
+		Note:       note,
+		RecordedBy: &recordedBy,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return fine, insertFine(ctx, m.DB, fine)
+}
+
+// Waive cancels some or all of what is left of a charge. An amount of zero waives the whole
+// remainder.
+func (m FineModel) Waive(chargeID, amount int64, note string, recordedBy int64) (*Fine, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+SELECT user_id, loan_id, amount
+FROM fines
+WHERE id = $1 AND kind = $2
+FOR UPDATE`
+
+	var charge Fine
+	err = tx.QueryRowContext(ctx, query, chargeID, FineKindCharge).Scan(&charge.UserID, &charge.LoanID, &charge.Amount)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	var waived int64
+	query = `SELECT COALESCE(-SUM(amount), 0) FROM fines WHERE charge_id = $1 AND kind = $2`
+	err = tx.QueryRowContext(ctx, query, chargeID, FineKindWaiver).Scan(&waived)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := charge.Amount - waived
+	if amount == 0 {
+		amount = remaining
+	}
+	if remaining <= 0 || amount > remaining {
+		return nil, ErrFineSettled
+	}
+
+	fine := &Fine{
+		UserID:     charge.UserID,
+		LoanID:     charge.LoanID,
+		ChargeID:   &chargeID,
+		Kind:       FineKindWaiver,
+		Amount:     -amount,
+		Note:       note,
+		RecordedBy: &recordedBy,
+	}
+
+	err = insertFine(ctx, tx, fine)
+	if err != nil {
+		return nil, err
+	}
+
+	return fine, tx.Commit()
+}
+
+// chargeOverdueFine adds the overdue charge for a loan that has just been returned, if it
+// was returned late.
+func chargeOverdueFine(ctx context.Context, tx *sql.Tx, loanID int64) error {
+	query := `
+INSERT INTO fines (user_id, loan_id, kind, amount, note)
+SELECT loans.user_id, loans.id, $2, ` + fmt.Sprintf(overdueAmountSQL, "loans.returned_at") + `, 'overdue'
+FROM loans
+INNER JOIN copies ON copies.id = loans.copy_id
+INNER JOIN fine_rules ON fine_rules.item_type = copies.item_type
+WHERE loans.id = $1 AND loans.returned_at > loans.due_at`
+
+	_, err := tx.ExecContext(ctx, query, loanID, FineKindCharge)
+	return err
+}
+
+func insertFine(ctx context.Context, db rowQueryer, fine *Fine) error {
+	query := `
+INSERT INTO fines (user_id, loan_id, charge_id, kind, amount, note, recorded_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, created_at`
+
+	args := []any{fine.UserID, fine.LoanID, fine.ChargeID, fine.Kind, fine.Amount, fine.Note, fine.RecordedBy}
+
+	return db.QueryRowContext(ctx, query, args...).Scan(&fine.ID, &fine.CreatedAt)
+}
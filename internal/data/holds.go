@@ -0,0 +1,444 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Eldiai/go_library/internal/validator"
+)
+
+// HoldPickupWindow is how long a patron has to collect a copy once their hold is ready.
+const HoldPickupWindow = 3 * 24 * time.Hour
+
+const (
+	HoldStatusWaiting   = "waiting"
+	HoldStatusInTransit = "in_transit"
+	HoldStatusReady     = "ready"
+	HoldStatusFulfilled = "fulfilled"
+	HoldStatusCancelled = "cancelled"
+	HoldStatusExpired   = "expired"
+)
+
+var (
+	ErrCopiesAvailable = errors.New("copies available")
+	ErrDuplicateHold   = errors.New("duplicate hold")
+	ErrHoldClosed      = errors.New("hold closed")
+	ErrHoldsPending    = errors.New("holds pending")
+)
+
+// Hold is a patron's place in the queue for a book. While waiting, Position is the patron's
+// 1-based place in the queue; once a copy comes back it is set aside for the hold (CopyID)
+// until ExpiresAt. A copy returned at another branch is first sent to PickupBranchID, and the
+// hold is in transit until it arrives.
+type Hold struct {
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	BookID         int64      `json:"book_id"`
+	UserID         int64      `json:"user_id"`
+	Status         string     `json:"status"`
+	Position       int        `json:"position,omitempty"`
+	PickupBranchID *int64     `json:"pickup_branch_id,omitempty"`
+	CopyID         *int64     `json:"copy_id,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	Version        int32      `json:"-"`
+}
+
+type HoldModel struct {
+	DB *sql.DB
+}
+
+// Place queues a user for a book, to be picked up at pickupBranchID (if set). Holds can only
+// be placed while none of the book's copies are on the shelf.
+func (m HoldModel) Place(bookID, userID int64, pickupBranchID *int64) (*Hold, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockBook(ctx, tx, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	var available int
+	query := `SELECT count(*) FROM copies WHERE book_id = $1 AND status = $2`
+	err = tx.QueryRowContext(ctx, query, bookID, CopyStatusAvailable).Scan(&available)
+	if err != nil {
+		return nil, err
+	}
+	if available > 0 {
+		return nil, ErrCopiesAvailable
+	}
+
+	hold := &Hold{
+		BookID:         bookID,
+		UserID:         userID,
+		Status:         HoldStatusWaiting,
+		PickupBranchID: pickupBranchID,
+	}
+
+	query = `
+INSERT INTO holds (book_id, user_id, status, pickup_branch_id)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, version`
+
+	args := []any{hold.BookID, hold.UserID, hold.Status, hold.PickupBranchID}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
+		&hold.ID,
+		&hold.CreatedAt,
+		&hold.Version,
+	)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), `duplicate key value violates unique constraint "holds_active_user_book_idx"`):
+			return nil, ErrDuplicateHold
+		default:
+			return nil, err
+		}
+	}
+
+	query = `SELECT count(*) FROM holds WHERE book_id = $1 AND status = $2 AND id <= $3`
+	err = tx.QueryRowContext(ctx, query, bookID, HoldStatusWaiting, hold.ID).Scan(&hold.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, tx.Commit()
+}
+
+func (m HoldModel) Get(id int64) (*Hold, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, book_id, user_id, status, pickup_branch_id, copy_id, expires_at, version
+FROM holds
+WHERE id = $1`
+
+	var hold Hold
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&hold.ID,
+		&hold.CreatedAt,
+		&hold.BookID,
+		&hold.UserID,
+		&hold.Status,
+		&hold.PickupBranchID,
+		&hold.CopyID,
+		&hold.ExpiresAt,
+		&hold.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &hold, nil
+}
+
+// GetAllForUser returns the holds a user has placed, newest first, with the queue position of
+// the ones still waiting.
+func (m HoldModel) GetAllForUser(userID int64) ([]*Hold, error) {
+	query := `
+SELECT h.id, h.created_at, h.book_id, h.user_id, h.status, h.pickup_branch_id, h.copy_id, h.expires_at, h.version,
+       CASE WHEN h.status = $2 THEN
+           (SELECT count(*) FROM holds w WHERE w.book_id = h.book_id AND w.status = $2 AND w.id <= h.id)
+       ELSE 0 END
+FROM holds h
+WHERE h.user_id = $1
+ORDER BY h.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, HoldStatusWaiting)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []*Hold{}
+	for rows.Next() {
+		var hold Hold
+		err := rows.Scan(
+			&hold.ID,
+			&hold.CreatedAt,
+			&hold.BookID,
+			&hold.UserID,
+			&hold.Status,
+			&hold.PickupBranchID,
+			&hold.CopyID,
+			&hold.ExpiresAt,
+			&hold.Version,
+			&hold.Position,
+		)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, &hold)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+// Cancel withdraws a hold. If a copy had already been set aside for it, the copy goes to the
+// next patron in the queue, whose hold is returned so they can be notified.
+func (m HoldModel) Cancel(hold *Hold) (*Hold, error) {
+	if !validator.In(hold.Status, HoldStatusWaiting, HoldStatusInTransit, HoldStatusReady) {
+		return nil, ErrHoldClosed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	next, err := closeHold(ctx, tx, hold, HoldStatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+
+	return next, tx.Commit()
+}
+
+// Expire closes every ready hold whose pickup window has passed and passes the copies on. It
+// returns the holds that became ready as a result, including when ctx is cancelled part way
+// through; each hold is expired in its own transaction, so none is left half done.
+func (m HoldModel) Expire(ctx context.Context) ([]*Hold, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+SELECT id, created_at, book_id, user_id, status, pickup_branch_id, copy_id, expires_at, version
+FROM holds
+WHERE status = $1 AND expires_at < NOW()
+ORDER BY id`
+
+	rows, err := m.DB.QueryContext(queryCtx, query, HoldStatusReady)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	expired := []*Hold{}
+	for rows.Next() {
+		var hold Hold
+		err := rows.Scan(
+			&hold.ID,
+			&hold.CreatedAt,
+			&hold.BookID,
+			&hold.UserID,
+			&hold.Status,
+			&hold.PickupBranchID,
+			&hold.CopyID,
+			&hold.ExpiresAt,
+			&hold.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, &hold)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ready := []*Hold{}
+	for _, hold := range expired {
+		if ctx.Err() != nil {
+			return ready, ctx.Err()
+		}
+		next, err := m.expire(ctx, hold)
+		if err != nil && !errors.Is(err, ErrEditConflict) {
+			return ready, err
+		}
+		if next != nil {
+			ready = append(ready, next)
+		}
+	}
+	return ready, nil
+}
+
+func (m HoldModel) expire(ctx context.Context, hold *Hold) (*Hold, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	next, err := closeHold(ctx, tx, hold, HoldStatusExpired)
+	if err != nil {
+		return nil, err
+	}
+
+	return next, tx.Commit()
+}
+
+// closeHold moves an open hold to a final status and, if the hold had a copy waiting on the
+// shelf for it, hands that copy to the next hold in the queue. A copy still travelling to the
+// hold's branch is dealt with when its transfer is received.
+func closeHold(ctx context.Context, tx *sql.Tx, hold *Hold, status string) (*Hold, error) {
+	err := lockBook(ctx, tx, hold.BookID)
+	if err != nil {
+		return nil, err
+	}
+
+	wasReady := hold.Status == HoldStatusReady
+
+	query := `
+UPDATE holds
+SET status = $1, version = version + 1
+WHERE id = $2 AND version = $3 AND status IN ($4, $5, $6)
+RETURNING status, version`
+
+	args := []any{status, hold.ID, hold.Version, HoldStatusWaiting, HoldStatusInTransit, HoldStatusReady}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&hold.Status, &hold.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	if !wasReady || hold.CopyID == nil {
+		return nil, nil
+	}
+	return fillNextHold(ctx, tx, hold.BookID, *hold.CopyID)
+}
+
+// fillNextHold sets a copy that has just become free aside for the first waiting hold on the
+// book. If the patron picks up at another branch, the copy is sent there with a transfer
+// instead and the hold waits for it to arrive. If nobody is waiting the copy goes back on the
+// shelf. Only a hold that is ready for pickup is returned. The book must already be locked by
+// the transaction.
+func fillNextHold(ctx context.Context, tx *sql.Tx, bookID, copyID int64) (*Hold, error) {
+	query := `
+SELECT id, created_at, book_id, user_id, status, pickup_branch_id, version
+FROM holds
+WHERE book_id = $1 AND status = $2
+ORDER BY id
+LIMIT 1
+FOR UPDATE`
+
+	var hold Hold
+	err := tx.QueryRowContext(ctx, query, bookID, HoldStatusWaiting).Scan(
+		&hold.ID,
+		&hold.CreatedAt,
+		&hold.BookID,
+		&hold.UserID,
+		&hold.Status,
+		&hold.PickupBranchID,
+		&hold.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, setCopyStatus(ctx, tx, copyID, CopyStatusAvailable)
+		default:
+			return nil, err
+		}
+	}
+
+	var branchID *int64
+	err = tx.QueryRowContext(ctx, `SELECT branch_id FROM copies WHERE id = $1`, copyID).Scan(&branchID)
+	if err != nil {
+		return nil, err
+	}
+
+	if branchID != nil && hold.PickupBranchID != nil && *branchID != *hold.PickupBranchID {
+		query = `
+UPDATE holds
+SET status = $1, copy_id = $2, version = version + 1
+WHERE id = $3`
+
+		_, err = tx.ExecContext(ctx, query, HoldStatusInTransit, copyID, hold.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		transfer := &Transfer{
+			CopyID:       copyID,
+			FromBranchID: branchID,
+			ToBranchID:   *hold.PickupBranchID,
+			HoldID:       &hold.ID,
+		}
+		return nil, insertTransfer(ctx, tx, transfer)
+	}
+
+	return &hold, readyHold(ctx, tx, &hold, copyID)
+}
+
+// readyHold sets a copy aside on the shelf for a hold and starts the pickup window.
+func readyHold(ctx context.Context, tx *sql.Tx, hold *Hold, copyID int64) error {
+	query := `
+UPDATE holds
+SET status = $1, copy_id = $2, expires_at = $3, version = version + 1
+WHERE id = $4
+RETURNING status, copy_id, expires_at, version`
+
+	args := []any{HoldStatusReady, copyID, time.Now().Add(HoldPickupWindow), hold.ID}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&hold.Status, &hold.CopyID, &hold.ExpiresAt, &hold.Version)
+	if err != nil {
+		return err
+	}
+
+	return setCopyStatus(ctx, tx, copyID, CopyStatusOnHold)
+}
+
+// lockBook takes a row lock on a book so that placing holds and freeing copies of that book
+// happen one at a time.
+func lockBook(ctx context.Context, tx *sql.Tx, bookID int64) error {
+	var id int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM books WHERE id = $1 FOR UPDATE`, bookID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// lockBookForCopy locks the book a copy belongs to and returns its id.
+func lockBookForCopy(ctx context.Context, tx *sql.Tx, copyID int64) (int64, error) {
+	var bookID int64
+	err := tx.QueryRowContext(ctx, `SELECT book_id FROM copies WHERE id = $1`, copyID).Scan(&bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return bookID, lockBook(ctx, tx, bookID)
+}
@@ -0,0 +1,349 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// LoanPeriod is how long a copy may be kept, both for a new loan and for every renewal.
+	LoanPeriod = 14 * 24 * time.Hour
+	// MaxRenewals is the number of times a single loan may be renewed.
+	MaxRenewals = 2
+)
+
+var (
+	ErrCopyUnavailable = errors.New("copy unavailable")
+	ErrLoanReturned    = errors.New("loan already returned")
+	ErrLoanOverdue     = errors.New("loan overdue")
+	ErrRenewalLimit    = errors.New("renewal limit reached")
+)
+
+type Loan struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	CopyID     int64      `json:"copy_id"`
+	UserID     int64      `json:"user_id"`
+	DueAt      time.Time  `json:"due_at"`
+	ReturnedAt *time.Time `json:"returned_at,omitempty"`
+	Renewals   int32      `json:"renewals"`
+	Version    int32      `json:"-"`
+}
+
+type LoanModel struct {
+	DB *sql.DB
+}
+
+// Checkout lends a copy to a user. The copy row is locked for the duration of the
+// transaction, so two patrons checking out the same copy can't both succeed. A copy that is
+// set aside for a hold can only be checked out by the patron who placed it, which fulfils
+// the hold.
+func (m LoanModel) Checkout(copyID, userID int64) (*Loan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM copies WHERE id = $1 FOR UPDATE`, copyID).Scan(&status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	switch status {
+	case CopyStatusAvailable:
+	case CopyStatusOnHold:
+		query := `
+UPDATE holds
+SET status = $1, version = version + 1
+WHERE copy_id = $2 AND user_id = $3 AND status = $4`
+
+		result, err := tx.ExecContext(ctx, query, HoldStatusFulfilled, copyID, userID, HoldStatusReady)
+		if err != nil {
+			return nil, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if rowsAffected == 0 {
+			return nil, ErrCopyUnavailable
+		}
+	default:
+		return nil, ErrCopyUnavailable
+	}
+
+	loan := &Loan{
+		CopyID: copyID,
+		UserID: userID,
+		DueAt:  time.Now().Add(LoanPeriod),
+	}
+
+	query := `
+INSERT INTO loans (copy_id, user_id, due_at)
+VALUES ($1, $2, $3)
+RETURNING id, created_at, renewals, version`
+
+	err = tx.QueryRowContext(ctx, query, loan.CopyID, loan.UserID, loan.DueAt).Scan(
+		&loan.ID,
+		&loan.CreatedAt,
+		&loan.Renewals,
+		&loan.Version,
+	)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), `duplicate key value violates unique constraint "loans_active_copy_id_idx"`):
+			return nil, ErrCopyUnavailable
+		default:
+			return nil, err
+		}
+	}
+
+	err = setCopyStatus(ctx, tx, copyID, CopyStatusOnLoan)
+	if err != nil {
+		return nil, err
+	}
+
+	return loan, tx.Commit()
+}
+
+func (m LoanModel) Get(id int64) (*Loan, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, copy_id, user_id, due_at, returned_at, renewals, version
+FROM loans
+WHERE id = $1`
+
+	var loan Loan
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&loan.ID,
+		&loan.CreatedAt,
+		&loan.CopyID,
+		&loan.UserID,
+		&loan.DueAt,
+		&loan.ReturnedAt,
+		&loan.Renewals,
+		&loan.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &loan, nil
+}
+
+// Renew extends the due date of an open loan by another LoanPeriod. Overdue loans have to be
+// returned instead, a loan can't be renewed more than MaxRenewals times, and it can't be
+// renewed at all while other patrons are waiting for the book.
+func (m LoanModel) Renew(loan *Loan) error {
+	switch {
+	case loan.ReturnedAt != nil:
+		return ErrLoanReturned
+	case time.Now().After(loan.DueAt):
+		return ErrLoanOverdue
+	case loan.Renewals >= MaxRenewals:
+		return ErrRenewalLimit
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+SELECT count(*)
+FROM holds
+INNER JOIN copies ON copies.book_id = holds.book_id
+WHERE copies.id = $1 AND holds.status = $2`
+
+	var waiting int
+	err := m.DB.QueryRowContext(ctx, query, loan.CopyID, HoldStatusWaiting).Scan(&waiting)
+	if err != nil {
+		return err
+	}
+	if waiting > 0 {
+		return ErrHoldsPending
+	}
+
+	query = `
+UPDATE loans
+SET due_at = $1, renewals = renewals + 1, reminder_sent_at = NULL, version = version + 1
+WHERE id = $2 AND version = $3 AND returned_at IS NULL
+RETURNING due_at, renewals, version`
+
+	err = m.DB.QueryRowContext(ctx, query, time.Now().Add(LoanPeriod), loan.ID, loan.Version).Scan(
+		&loan.DueAt,
+		&loan.Renewals,
+		&loan.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Return closes an open loan, charging an overdue fine if it is late. The copy is set aside for the next hold on the book, which is
+// returned so the patron can be notified, or goes back on the shelf if nobody is waiting.
+func (m LoanModel) Return(loan *Loan) (*Hold, error) {
+	if loan.ReturnedAt != nil {
+		return nil, ErrLoanReturned
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bookID, err := lockBookForCopy(ctx, tx, loan.CopyID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+UPDATE loans
+SET returned_at = NOW(), version = version + 1
+WHERE id = $1 AND version = $2 AND returned_at IS NULL
+RETURNING returned_at, version`
+
+	err = tx.QueryRowContext(ctx, query, loan.ID, loan.Version).Scan(&loan.ReturnedAt, &loan.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	err = chargeOverdueFine(ctx, tx, loan.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	hold, err := fillNextHold(ctx, tx, bookID, loan.CopyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, tx.Commit()
+}
+
+// LoanNotice holds what a reminder email about a loan needs to say.
+type LoanNotice struct {
+	LoanID    int64
+	DueAt     time.Time
+	UserName  string
+	UserEmail string
+	BookTitle string
+}
+
+// GetDueSoon returns open loans due within the given duration whose borrower hasn't been
+// reminded yet.
+func (m LoanModel) GetDueSoon(ctx context.Context, within time.Duration) ([]*LoanNotice, error) {
+	query := `
+SELECT loans.id, loans.due_at, users.name, users.email, books.title
+FROM loans
+INNER JOIN users ON users.id = loans.user_id
+INNER JOIN copies ON copies.id = loans.copy_id
+INNER JOIN books ON books.id = copies.book_id
+WHERE loans.returned_at IS NULL
+AND loans.reminder_sent_at IS NULL
+AND loans.due_at BETWEEN NOW() AND $1
+ORDER BY loans.due_at`
+
+	return m.getNotices(ctx, query, time.Now().Add(within))
+}
+
+// GetOverdue returns open loans past their due date whose borrower hasn't been sent an
+// overdue notice in the given duration.
+func (m LoanModel) GetOverdue(ctx context.Context, every time.Duration) ([]*LoanNotice, error) {
+	query := `
+SELECT loans.id, loans.due_at, users.name, users.email, books.title
+FROM loans
+INNER JOIN users ON users.id = loans.user_id
+INNER JOIN copies ON copies.id = loans.copy_id
+INNER JOIN books ON books.id = copies.book_id
+WHERE loans.returned_at IS NULL
+AND loans.due_at < NOW()
+AND (loans.overdue_notice_sent_at IS NULL OR loans.overdue_notice_sent_at < $1)
+ORDER BY loans.due_at`
+
+	return m.getNotices(ctx, query, time.Now().Add(-every))
+}
+
+func (m LoanModel) getNotices(ctx context.Context, query string, args ...any) ([]*LoanNotice, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notices := []*LoanNotice{}
+	for rows.Next() {
+		var notice LoanNotice
+		err := rows.Scan(
+			&notice.LoanID,
+			&notice.DueAt,
+			&notice.UserName,
+			&notice.UserEmail,
+			&notice.BookTitle,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notices = append(notices, &notice)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return notices, nil
+}
+
+// MarkReminded records that the due-soon reminder for a loan has been sent.
+func (m LoanModel) MarkReminded(loanID int64) error {
+	return m.markNotified(`UPDATE loans SET reminder_sent_at = NOW() WHERE id = $1`, loanID)
+}
+
+// MarkOverdueNotified records that an overdue notice for a loan has been sent.
+func (m LoanModel) MarkOverdueNotified(loanID int64) error {
+	return m.markNotified(`UPDATE loans SET overdue_notice_sent_at = NOW() WHERE id = $1`, loanID)
+}
+
+func (m LoanModel) markNotified(query string, loanID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, loanID)
+	return err
+}
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -10,24 +12,57 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// rowQueryer is satisfied by both *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Models struct {
 	Books interface {
 		Insert(book *Book) error
+		InsertBatch(books []*Book) error
 		Get(id int64) (*Book, error)
 		Update(book *Book) error
-		Delete(id int64) error
-		GetAll(title string, author string, genres []string, filters Filters) ([]*Book, Metadata, error)
+		Delete(id int64, version int32) error
+		GetAll(search BookSearch, filters Filters) ([]*Book, Metadata, error)
+		GetFacets(search BookSearch, names []string) (map[string][]Facet, error)
+		Export(ctx context.Context, search BookSearch, filters Filters, fn func(*Book) error) error
+		Suggest(prefix string, limit int) ([]*BookSuggestion, error)
+	}
+	Copies    CopyModel
+	Loans     LoanModel
+	Holds     HoldModel
+	Fines     FineModel
+	Branches  BranchModel
+	Transfers TransferModel
+	Emails    interface {
+		Insert(email *Email) error
+		ClaimDue(ctx context.Context, limit int) ([]*Email, error)
+		GetAllByStatus(status string, limit int) ([]*Email, error)
+		MarkSent(email *Email) error
+		MarkFailed(email *Email, deliveryErr error, maxAttempts int, backoff time.Duration) error
+		Requeue(id int64) (*Email, error)
+		DeleteSettled(ctx context.Context, age time.Duration) (int64, error)
 	}
 	Users       UserModel
 	Tokens      TokenModel
 	Permissions PermissionModel
+	Roles       RoleModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
 		Books:       BookModel{DB: db},
+		Copies:      CopyModel{DB: db},
+		Loans:       LoanModel{DB: db},
+		Holds:       HoldModel{DB: db},
+		Fines:       FineModel{DB: db},
+		Branches:    BranchModel{DB: db},
+		Transfers:   TransferModel{DB: db},
+		Emails:      EmailModel{DB: db},
 		Tokens:      TokenModel{DB: db},
 		Permissions: PermissionModel{DB: db},
+		Roles:       RoleModel{DB: db},
 		Users:       UserModel{DB: db},
 	}
 }
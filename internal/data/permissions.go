@@ -22,41 +22,111 @@ type PermissionModel struct {
 	DB *sql.DB
 }
 
+// GetAllForUser returns the permissions a user has, whether granted directly or through one
+// of their roles.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
 SELECT permissions.code
 FROM permissions
 INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-INNER JOIN users ON users_permissions.user_id = users.id
-WHERE users.id = $1`
+WHERE users_permissions.user_id = $1
+UNION
+SELECT permissions.code
+FROM permissions
+INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+WHERE users_roles.user_id = $1
+ORDER BY code`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanCodes(rows)
+}
+
+// GetGrantedForUser returns only the permissions granted to a user directly.
+func (m PermissionModel) GetGrantedForUser(userID int64) (Permissions, error) {
+	query := `
+SELECT permissions.code
+FROM permissions
+INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+WHERE users_permissions.user_id = $1
+ORDER BY permissions.code`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
+	return scanCodes(rows)
+}
+
+// GetAll returns every permission code there is.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+SELECT code
+FROM permissions
+ORDER BY code`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return scanCodes(rows)
+}
+
+// scanCodes reads a single column of codes and closes rows.
+func scanCodes(rows *sql.Rows) ([]string, error) {
 	defer rows.Close()
-	var permissions Permissions
+	codes := []string{}
 	for rows.Next() {
-		var permission string
-		err := rows.Scan(&permission)
+		var code string
+		err := rows.Scan(&code)
 		if err != nil {
 			return nil, err
 		}
-		permissions = append(permissions, permission)
+		codes = append(codes, code)
 	}
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return permissions, nil
+	return codes, nil
 }
 
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `
 INSERT INTO users_permissions
-SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+ON CONFLICT DO NOTHING`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// RemoveForUser revokes permissions granted to a user directly. Permissions the user has
+// through a role are unaffected. ErrRecordNotFound is returned if none were granted.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+DELETE FROM users_permissions
+USING permissions
+WHERE users_permissions.permission_id = permissions.id
+AND users_permissions.user_id = $1 AND permissions.code = ANY($2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
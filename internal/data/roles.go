@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const RolePatron = "patron"
+
+// Role is a named bundle of permissions.
+type Role struct {
+	Code        string      `json:"code"`
+	Permissions Permissions `json:"permissions"`
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns every role along with its permissions.
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+SELECT roles.code, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+FROM roles
+LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+GROUP BY roles.id
+ORDER BY roles.id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		var permissions []string
+		err := rows.Scan(&role.Code, pq.Array(&permissions))
+		if err != nil {
+			return nil, err
+		}
+		role.Permissions = permissions
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+SELECT roles.code
+FROM roles
+INNER JOIN users_roles ON users_roles.role_id = roles.id
+WHERE users_roles.user_id = $1
+ORDER BY roles.id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanCodes(rows)
+}
+
+func (m RoleModel) AddForUser(userID int64, codes ...string) error {
+	query := `
+INSERT INTO users_roles
+SELECT $1, roles.id FROM roles WHERE roles.code = ANY($2)
+ON CONFLICT DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// RemoveForUser takes roles away from a user. ErrRecordNotFound is returned if the user had
+// none of them.
+func (m RoleModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+DELETE FROM users_roles
+USING roles
+WHERE users_roles.role_id = roles.id
+AND users_roles.user_id = $1 AND roles.code = ANY($2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -13,6 +13,8 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
 )
 
 type Token struct {
@@ -21,8 +23,22 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	UserAgent string    `json:"-"`
 }
 
+// Session is an authentication token as its owner sees it, without the token itself.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	UserAgent  string     `json:"user_agent"`
+	Expiry     time.Time  `json:"expiry"`
+	Current    bool       `json:"current"`
+}
+
+// sessionTouchInterval limits how often a session's last_used_at is written.
+const sessionTouchInterval = time.Minute
+
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 
 	token := &Token{
@@ -61,11 +77,22 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	err = m.Insert(token)
 	return token, err
 }
+
+// NewSession creates an authentication token, recording the user agent it was issued to.
+func (m TokenModel) NewSession(userID int64, ttl time.Duration, userAgent string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	token.UserAgent = userAgent
+	err = m.Insert(token)
+	return token, err
+}
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-INSERT INTO tokens (hash, user_id, expiry, scope)
-VALUES ($1, $2, $3, $4)`
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+INSERT INTO tokens (hash, user_id, expiry, scope, user_agent)
+VALUES ($1, $2, $3, $4, $5)`
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -82,3 +109,83 @@ WHERE scope = $1 AND user_id = $2`
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAllForUserExcept() deletes all tokens for a specific user and scope, other than the
+// one given.
+func (m TokenModel) DeleteAllForUserExcept(scope string, userID int64, keepPlaintext string) error {
+	keepHash := sha256.Sum256([]byte(keepPlaintext))
+	query := `
+DELETE FROM tokens
+WHERE scope = $1 AND user_id = $2 AND hash <> $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, userID, keepHash[:])
+	return err
+}
+
+// DeleteExpired() deletes every token that has expired, and returns how many there were.
+func (m TokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+DELETE FROM tokens
+WHERE expiry < $1`
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Delete() deletes a single token.
+func (m TokenModel) Delete(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+DELETE FROM tokens
+WHERE scope = $1 AND hash = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, tokenHash[:])
+	return err
+}
+
+// GetSessionsForUser() lists a user's unexpired authentication tokens, newest first, marking
+// the one that matches currentPlaintext.
+func (m TokenModel) GetSessionsForUser(userID int64, currentPlaintext string) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentPlaintext))
+	query := `
+SELECT id, created_at, last_used_at, user_agent, expiry, hash = $1
+FROM tokens
+WHERE user_id = $2 AND scope = $3 AND expiry > $4
+ORDER BY created_at DESC, id DESC`
+	args := []interface{}{currentHash[:], userID, ScopeAuthentication, time.Now()}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.UserAgent,
+			&session.Expiry,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
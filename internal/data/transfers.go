@@ -0,0 +1,328 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	TransferStatusRequested = "requested"
+	TransferStatusShipped   = "shipped"
+	TransferStatusReceived  = "received"
+	TransferStatusCancelled = "cancelled"
+)
+
+var (
+	ErrSameBranch      = errors.New("same branch")
+	ErrTransferClosed  = errors.New("transfer closed")
+	ErrTransferForHold = errors.New("transfer for hold")
+)
+
+// Transfer moves a copy from one branch to another, either because staff asked for it or to
+// fill a hold (HoldID) at the patron's pickup branch.
+type Transfer struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	CopyID       int64     `json:"copy_id"`
+	FromBranchID *int64    `json:"from_branch_id,omitempty"`
+	ToBranchID   int64     `json:"to_branch_id"`
+	HoldID       *int64    `json:"hold_id,omitempty"`
+	Status       string    `json:"status"`
+	Version      int32     `json:"-"`
+}
+
+type TransferModel struct {
+	DB *sql.DB
+}
+
+// Request sends a copy that is on the shelf to another branch.
+func (m TransferModel) Request(copyID, toBranchID int64) (*Transfer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var branchID *int64
+	query := `SELECT status, branch_id FROM copies WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, copyID).Scan(&status, &branchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if status != CopyStatusAvailable {
+		return nil, ErrCopyUnavailable
+	}
+	if branchID != nil && *branchID == toBranchID {
+		return nil, ErrSameBranch
+	}
+
+	transfer := &Transfer{
+		CopyID:       copyID,
+		FromBranchID: branchID,
+		ToBranchID:   toBranchID,
+	}
+
+	err = insertTransfer(ctx, tx, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, tx.Commit()
+}
+
+func (m TransferModel) Get(id int64) (*Transfer, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, copy_id, from_branch_id, to_branch_id, hold_id, status, version
+FROM transfers
+WHERE id = $1`
+
+	var transfer Transfer
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&transfer.ID,
+		&transfer.CreatedAt,
+		&transfer.CopyID,
+		&transfer.FromBranchID,
+		&transfer.ToBranchID,
+		&transfer.HoldID,
+		&transfer.Status,
+		&transfer.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &transfer, nil
+}
+
+// GetAll lists transfers, optionally only those with the given status and those leaving or
+// arriving at the given branch.
+func (m TransferModel) GetAll(status string, branchID int64) ([]*Transfer, error) {
+	query := `
+SELECT id, created_at, copy_id, from_branch_id, to_branch_id, hold_id, status, version
+FROM transfers
+WHERE (status = $1 OR $1 = '')
+AND (from_branch_id = $2 OR to_branch_id = $2 OR $2 = 0)
+ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, branchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []*Transfer{}
+	for rows.Next() {
+		var transfer Transfer
+		err := rows.Scan(
+			&transfer.ID,
+			&transfer.CreatedAt,
+			&transfer.CopyID,
+			&transfer.FromBranchID,
+			&transfer.ToBranchID,
+			&transfer.HoldID,
+			&transfer.Status,
+			&transfer.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, &transfer)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// Ship marks a requested transfer as sent on its way.
+func (m TransferModel) Ship(transfer *Transfer) error {
+	if transfer.Status != TransferStatusRequested {
+		return ErrTransferClosed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return setTransferStatus(ctx, m.DB, transfer, TransferStatusShipped)
+}
+
+// Receive books a copy in at its new branch. If the transfer was made for a hold that is still
+// open, the copy is set aside for it and the hold is returned so the patron can be notified.
+// Otherwise the copy goes to the next hold on the book, or on the shelf.
+func (m TransferModel) Receive(transfer *Transfer) (*Hold, error) {
+	if transfer.Status != TransferStatusRequested && transfer.Status != TransferStatusShipped {
+		return nil, ErrTransferClosed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bookID, err := lockBookForCopy(ctx, tx, transfer.CopyID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setTransferStatus(ctx, tx, transfer, TransferStatusReceived)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE copies SET branch_id = $1, version = version + 1 WHERE id = $2`, transfer.ToBranchID, transfer.CopyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if transfer.HoldID != nil {
+		var hold Hold
+		query := `
+SELECT id, created_at, book_id, user_id, status, pickup_branch_id, version
+FROM holds
+WHERE id = $1
+FOR UPDATE`
+
+		err = tx.QueryRowContext(ctx, query, *transfer.HoldID).Scan(
+			&hold.ID,
+			&hold.CreatedAt,
+			&hold.BookID,
+			&hold.UserID,
+			&hold.Status,
+			&hold.PickupBranchID,
+			&hold.Version,
+		)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if err == nil && hold.Status == HoldStatusInTransit {
+			err = readyHold(ctx, tx, &hold, transfer.CopyID)
+			if err != nil {
+				return nil, err
+			}
+			return &hold, tx.Commit()
+		}
+	}
+
+	hold, err := fillNextHold(ctx, tx, bookID, transfer.CopyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, tx.Commit()
+}
+
+// Cancel calls off a transfer that hasn't been shipped yet. Transfers made for a hold can only
+// be called off by cancelling the hold.
+func (m TransferModel) Cancel(transfer *Transfer) (*Hold, error) {
+	if transfer.Status != TransferStatusRequested {
+		return nil, ErrTransferClosed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bookID, err := lockBookForCopy(ctx, tx, transfer.CopyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if transfer.HoldID != nil {
+		var status string
+		err = tx.QueryRowContext(ctx, `SELECT status FROM holds WHERE id = $1`, *transfer.HoldID).Scan(&status)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if status == HoldStatusInTransit {
+			return nil, ErrTransferForHold
+		}
+	}
+
+	err = setTransferStatus(ctx, tx, transfer, TransferStatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+
+	hold, err := fillNextHold(ctx, tx, bookID, transfer.CopyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, tx.Commit()
+}
+
+// insertTransfer records a new transfer and takes the copy off the shelf while it travels.
+func insertTransfer(ctx context.Context, tx *sql.Tx, transfer *Transfer) error {
+	query := `
+INSERT INTO transfers (copy_id, from_branch_id, to_branch_id, hold_id, status)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at, status, version`
+
+	transfer.Status = TransferStatusRequested
+	args := []any{transfer.CopyID, transfer.FromBranchID, transfer.ToBranchID, transfer.HoldID, transfer.Status}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(
+		&transfer.ID,
+		&transfer.CreatedAt,
+		&transfer.Status,
+		&transfer.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	return setCopyStatus(ctx, tx, transfer.CopyID, CopyStatusInTransit)
+}
+
+func setTransferStatus(ctx context.Context, db rowQueryer, transfer *Transfer, status string) error {
+	query := `
+UPDATE transfers
+SET status = $1, version = version + 1
+WHERE id = $2 AND version = $3
+RETURNING status, version`
+
+	err := db.QueryRowContext(ctx, query, status, transfer.ID, transfer.Version).Scan(&transfer.Status, &transfer.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
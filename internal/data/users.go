@@ -5,25 +5,31 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/Eldiai/go_library/internal/validator"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
+	"strings"
 	"time"
 )
 
 var (
 	ErrDuplicateEmail = errors.New("duplicate email")
+	ErrUserHasItems   = errors.New("user has items checked out or set aside")
 )
 
 var AnonymousUser = &User{}
 
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	Password     password  `json:"-"`
+	Activated    bool      `json:"activated"`
+	BranchID     *int64    `json:"branch_id,omitempty"`
+	PendingEmail *string   `json:"pending_email,omitempty"`
+	Version      int       `json:"-"`
 }
 type UserModel struct {
 	DB *sql.DB
@@ -48,10 +54,10 @@ func (p *password) Set(plaintextPassword string) error {
 }
 func (m UserModel) Insert(user *User) error {
 	query := `
-INSERT INTO users (name, email, password_hash, activated)
-VALUES ($1, $2, $3, $4)
+INSERT INTO users (name, email, password_hash, activated, branch_id)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING id, created_at, version`
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.BranchID}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	// If the table already contains a record with this email address, then when we try
@@ -61,7 +67,7 @@ RETURNING id, created_at, version`
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case strings.Contains(err.Error(), `duplicate key value violates unique constraint "users_email_key"`):
 			return ErrDuplicateEmail
 		default:
 			return err
@@ -72,14 +78,16 @@ RETURNING id, created_at, version`
 func (m UserModel) Update(user *User) error {
 	query := `
 UPDATE users
-SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-WHERE id = $5 AND version = $6
+SET name = $1, email = $2, password_hash = $3, activated = $4, branch_id = $5, pending_email = $6, version = version + 1
+WHERE id = $7 AND version = $8
 RETURNING version`
 	args := []any{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.BranchID,
+		user.PendingEmail,
 		user.ID,
 		user.Version,
 	}
@@ -88,7 +96,7 @@ RETURNING version`
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case strings.Contains(err.Error(), `duplicate key value violates unique constraint "users_email_key"`):
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -135,7 +143,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-SELECT id, created_at, name, email, password_hash, activated, version
+SELECT id, created_at, name, email, password_hash, activated, branch_id, pending_email, version
 FROM users
 WHERE email = $1`
 	var user User
@@ -148,6 +156,40 @@ WHERE email = $1`
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.BranchID,
+		&user.PendingEmail,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, created_at, name, email, password_hash, activated, branch_id, pending_email, version
+FROM users
+WHERE id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.BranchID,
+		&user.PendingEmail,
 		&user.Version,
 	)
 	if err != nil {
@@ -164,7 +206,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	query := `
-SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.branch_id, users.pending_email, users.version
 FROM users
 INNER JOIN tokens
 ON users.id = tokens.user_id
@@ -184,6 +226,8 @@ AND tokens.expiry > $3`
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.BranchID,
+		&user.PendingEmail,
 		&user.Version,
 	)
 	if err != nil {
@@ -196,3 +240,176 @@ AND tokens.expiry > $3`
 	}
 	return &user, nil
 }
+
+// userPermissionCodes is an expression for the codes of every permission the user in the
+// users table has, granted directly or through a role.
+const userPermissionCodes = `ARRAY(
+           SELECT permissions.code
+           FROM permissions
+           INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+           WHERE users_permissions.user_id = users.id
+           UNION
+           SELECT permissions.code
+           FROM permissions
+           INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+           INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+           WHERE users_roles.user_id = users.id
+       )`
+
+// GetForSession returns the user an authentication token belongs to, and with
+// withPermissions their permissions too. The same statement records that the token has been
+// used, but only writes last_used_at once every sessionTouchInterval, so that reads don't
+// turn into a write each.
+func (m UserModel) GetForSession(tokenPlaintext string, withPermissions bool) (*User, Permissions, error) {
+	permissions := "'{}'::text[]"
+	if withPermissions {
+		permissions = userPermissionCodes
+	}
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+WITH touched AS (
+    UPDATE tokens
+    SET last_used_at = $4
+    WHERE hash = $1 AND scope = $2 AND expiry > $3
+    AND (last_used_at IS NULL OR last_used_at < $5)
+)
+SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.branch_id, users.pending_email, users.version,
+       ` + permissions + `
+FROM users
+INNER JOIN tokens
+ON users.id = tokens.user_id
+WHERE tokens.hash = $1
+AND tokens.scope = $2
+AND tokens.expiry > $3`
+
+	now := time.Now()
+	args := []interface{}{tokenHash[:], ScopeAuthentication, now, now, now.Add(-sessionTouchInterval)}
+	var user User
+	var codes []string
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.BranchID,
+		&user.PendingEmail,
+		&user.Version,
+		pq.Array(&codes),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	if !withPermissions {
+		return &user, nil, nil
+	}
+	return &user, codes, nil
+}
+
+// GetAll lists users whose name and email contain the given strings, taken literally rather
+// than as LIKE patterns. A nil activated matches both activated and unactivated users.
+func (m UserModel) GetAll(name, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, name, email, activated, branch_id, pending_email, version
+FROM users
+WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '')
+AND (email ILIKE '%%' || $2 || '%%' OR $2 = '')
+AND (activated = $3 OR $3 IS NULL)
+ORDER BY %s %s, id ASC
+LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{likeEscaper.Replace(name), likeEscaper.Replace(email), activated, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.BranchID,
+			&user.PendingEmail,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// Delete removes a user along with their tokens, permissions and history. Users who still
+// have a copy checked out or set aside for them can't be deleted, as the copy would be left
+// stranded.
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hasItems bool
+	query := `
+SELECT EXISTS (SELECT 1 FROM loans WHERE user_id = users.id AND returned_at IS NULL)
+    OR EXISTS (SELECT 1 FROM holds WHERE user_id = users.id AND status IN ($2, $3))
+FROM users
+WHERE id = $1
+FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, id, HoldStatusInTransit, HoldStatusReady).Scan(&hasItems)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if hasItems {
+		return ErrUserHasItems
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -12,9 +12,23 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
+// Message is an email rendered from a template, ready to be delivered.
+type Message struct {
+	Recipient string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Queue stores messages so they can be delivered later, and retried if delivery fails.
+type Queue interface {
+	Enqueue(msg *Message) error
+}
+
 type Mailer struct {
 	dialer *mail.Dialer
 	sender string
+	queue  Queue
 }
 
 func New(host string, port int, username, password, sender string) Mailer {
@@ -26,39 +40,70 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
+// WithQueue returns a copy of the mailer whose Send puts messages on queue instead of
+// delivering them straight away.
+func (m Mailer) WithQueue(queue Queue) Mailer {
+	m.queue = queue
+	return m
+}
+
+// Send renders a template and either queues the message or, without a queue, delivers it.
 func (m Mailer) Send(recipient, templateFile string, data any) error {
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	msg, err := m.Render(recipient, templateFile, data)
 	if err != nil {
 		return err
 	}
 
+	if m.queue != nil {
+		return m.queue.Enqueue(msg)
+	}
+	return m.Deliver(msg)
+}
+
+// Render executes the "subject", "plainBody" and "htmlBody" templates in templateFile.
+func (m Mailer) Render(recipient, templateFile string, data any) (*Message, error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
+
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// And likewise with the "htmlBody" template.
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	return &Message{
+		Recipient: recipient,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
+
+// Deliver sends a rendered message over SMTP.
+func (m Mailer) Deliver(message *Message) error {
 	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
+	msg.SetHeader("To", message.Recipient)
 	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
+	msg.SetHeader("Subject", message.Subject)
+	msg.SetBody("text/plain", message.PlainBody)
+	msg.AddAlternative("text/html", message.HTMLBody)
 
-	err = m.dialer.DialAndSend(msg)
+	err := m.dialer.DialAndSend(msg)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	windowStart time.Time
+	previous    int
+	current     int
+	expires     time.Time
+}
+
+// Memory is a RateLimiter which keeps its counts in memory, so each instance of the
+// application enforces its limits on its own.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+// NewMemory returns a Memory limiter, which forgets idle clients once a minute until ctx is
+// cancelled.
+func NewMemory(ctx context.Context) *Memory {
+	m := &Memory{entries: make(map[string]*memoryEntry)}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				m.mu.Lock()
+				for key, entry := range m.entries {
+					if now.After(entry.expires) {
+						delete(m.entries, key)
+					}
+				}
+				m.mu.Unlock()
+			}
+		}
+	}()
+
+	return m
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if err := limit.Validate(); err != nil {
+		return Result{}, err
+	}
+
+	now := time.Now()
+	windowStart := now.Truncate(limit.Window)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, found := m.entries[key]
+	switch {
+	case !found:
+		entry = &memoryEntry{windowStart: windowStart}
+		m.entries[key] = entry
+	case entry.windowStart.Equal(windowStart.Add(-limit.Window)):
+		entry.windowStart, entry.previous, entry.current = windowStart, entry.current, 0
+	case !entry.windowStart.Equal(windowStart):
+		entry.windowStart, entry.previous, entry.current = windowStart, 0, 0
+	}
+	entry.current++
+	entry.expires = windowStart.Add(2 * limit.Window)
+
+	return slidingWindow(limit, now, windowStart, entry.previous, entry.current), nil
+}
+
+func (m *Memory) Check(ctx context.Context, key string, limit Limit) (Result, error) {
+	if err := limit.Validate(); err != nil {
+		return Result{}, err
+	}
+
+	now := time.Now()
+	windowStart := now.Truncate(limit.Window)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var previous, current int
+	if entry, found := m.entries[key]; found {
+		switch {
+		case entry.windowStart.Equal(windowStart):
+			previous, current = entry.previous, entry.current
+		case entry.windowStart.Equal(windowStart.Add(-limit.Window)):
+			previous = entry.current
+		}
+	}
+
+	return slidingWindow(limit, now, windowStart, previous, current+1), nil
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Postgres is a RateLimiter which keeps its counts in the rate_limits table, so that limits
+// hold across every instance of the application sharing the database.
+type Postgres struct {
+	DB *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{DB: db}
+}
+
+func (p *Postgres) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if err := limit.Validate(); err != nil {
+		return Result{}, err
+	}
+
+	now := time.Now()
+	windowStart := now.Truncate(limit.Window)
+
+	query := `
+WITH current AS (
+    INSERT INTO rate_limits (key, window_start, count, expires_at)
+    VALUES ($1, $2, 1, $3)
+    ON CONFLICT (key, window_start) DO UPDATE SET count = rate_limits.count + 1
+    RETURNING count
+)
+SELECT current.count,
+       COALESCE((SELECT count FROM rate_limits WHERE key = $1 AND window_start = $4), 0)
+FROM current`
+
+	args := []any{key, windowStart, windowStart.Add(2 * limit.Window), windowStart.Add(-limit.Window)}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var previous, current int
+	err := p.DB.QueryRowContext(ctx, query, args...).Scan(&current, &previous)
+	if err != nil {
+		return Result{}, err
+	}
+	return slidingWindow(limit, now, windowStart, previous, current), nil
+}
+
+func (p *Postgres) Check(ctx context.Context, key string, limit Limit) (Result, error) {
+	if err := limit.Validate(); err != nil {
+		return Result{}, err
+	}
+
+	now := time.Now()
+	windowStart := now.Truncate(limit.Window)
+
+	query := `
+SELECT COALESCE(SUM(count) FILTER (WHERE window_start = $2), 0),
+       COALESCE(SUM(count) FILTER (WHERE window_start = $3), 0)
+FROM rate_limits
+WHERE key = $1 AND window_start IN ($2, $3)`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var previous, current int
+	err := p.DB.QueryRowContext(ctx, query, key, windowStart, windowStart.Add(-limit.Window)).Scan(&current, &previous)
+	if err != nil {
+		return Result{}, err
+	}
+	return slidingWindow(limit, now, windowStart, previous, current+1), nil
+}
+
+// DeleteExpired deletes the counts for windows which no longer affect any limit, and returns
+// how many there were.
+func (p *Postgres) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+DELETE FROM rate_limits
+WHERE expires_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := p.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
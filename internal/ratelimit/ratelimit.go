@@ -0,0 +1,85 @@
+// Package ratelimit limits how many requests a client can make in a window of time.
+//
+// Both limiters use a sliding window counter: requests are counted in fixed windows, and the
+// count for the window before the current one is weighted by how much of it still overlaps
+// the sliding window. Rejected requests are counted too, so a client has to back off before
+// it gets through again.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrInvalidLimit is returned for a Limit which doesn't allow any requests, or whose window
+// is empty.
+var ErrInvalidLimit = errors.New("ratelimit: requests and window must be greater than zero")
+
+// Limit is the number of requests allowed in a window.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Validate returns ErrInvalidLimit unless both the requests and the window are greater than
+// zero. Limits taken from configuration should be checked at startup, but Allow checks them
+// too, since a zero window would otherwise divide by zero.
+func (l Limit) Validate() error {
+	if l.Requests <= 0 || l.Window <= 0 {
+		return ErrInvalidLimit
+	}
+	return nil
+}
+
+// Result describes the outcome of a request, in the terms of the RateLimit headers.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the current window ends.
+	Reset time.Duration
+	// RetryAfter is how long a rejected client should wait before trying again.
+	RetryAfter time.Duration
+}
+
+// RateLimiter counts requests from a key against a limit.
+type RateLimiter interface {
+	// Allow counts a request from key against limit.
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	// Check reports whether a request from key would be allowed, without counting it. It lets
+	// a request be turned away before doing work which is only counted when it fails.
+	Check(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// slidingWindow works out the Result for a request, given the counts for the previous and
+// the current window, the latter including this request.
+func slidingWindow(limit Limit, now, windowStart time.Time, previous, current int) Result {
+	elapsed := now.Sub(windowStart)
+	weight := 1 - float64(elapsed)/float64(limit.Window)
+	estimate := float64(previous)*weight + float64(current)
+
+	result := Result{
+		Allowed:   estimate <= float64(limit.Requests),
+		Limit:     limit.Requests,
+		Remaining: int(float64(limit.Requests) - estimate),
+		Reset:     limit.Window - elapsed,
+	}
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+
+	if !result.Allowed {
+		// The next request gets through once enough of the previous window has slid out.
+		if current+1 > limit.Requests || previous == 0 {
+			result.RetryAfter = result.Reset
+		} else {
+			at := 1 - float64(limit.Requests-current-1)/float64(previous)
+			result.RetryAfter = time.Duration(at*float64(limit.Window)) - elapsed
+		}
+		if result.RetryAfter < 0 {
+			result.RetryAfter = 0
+		}
+	}
+	return result
+}
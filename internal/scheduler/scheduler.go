@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Eldiai/go_library/internal/jsonlog"
+)
+
+// Job is a named task which the Scheduler runs at a fixed interval.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Status describes the most recent run of a job.
+type Status struct {
+	Name         string     `json:"name"`
+	Interval     string     `json:"interval"`
+	Running      bool       `json:"running"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	Runs         int        `json:"runs"`
+	Failures     int        `json:"failures"`
+}
+
+// Scheduler runs jobs in the background, each in its own goroutine, and keeps track of how
+// their last run went.
+type Scheduler struct {
+	logger *jsonlog.Logger
+	mu     sync.Mutex
+	jobs   []Job
+	status map[string]*Status
+}
+
+// New returns a Scheduler which logs job runs to logger.
+func New(logger *jsonlog.Logger) *Scheduler {
+	return &Scheduler{
+		logger: logger,
+		status: make(map[string]*Status),
+	}
+}
+
+// ErrInvalidInterval is returned by Add for a job whose interval isn't greater than zero,
+// which a ticker can't run.
+var ErrInvalidInterval = errors.New("scheduler: interval must be greater than zero")
+
+// Add registers a job. It must be called before Start.
+func (s *Scheduler) Add(job Job) error {
+	if job.Interval <= 0 {
+		return fmt.Errorf("%w: job %q", ErrInvalidInterval, job.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs = append(s.jobs, job)
+	s.status[job.Name] = &Status{
+		Name:     job.Name,
+		Interval: job.Interval.String(),
+	}
+	return nil
+}
+
+// Start runs every job straight away, and then once per interval until ctx is cancelled. Each
+// job goroutine is tracked by wg, so the caller can wait for running jobs to finish on
+// shutdown. Jobs are passed ctx, and should stop early once it is cancelled.
+func (s *Scheduler) Start(ctx context.Context, wg *sync.WaitGroup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		job := job
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			s.run(ctx, job)
+
+			ticker := time.NewTicker(job.Interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					s.run(ctx, job)
+				}
+			}
+		}()
+	}
+}
+
+// Statuses returns the status of every job, ordered by name.
+func (s *Scheduler) Statuses() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]Status, 0, len(s.status))
+	for _, status := range s.status {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// run executes a single run of a job, recovering from any panic so that one bad run doesn't
+// stop the job, or the server, for good.
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	s.mu.Lock()
+	s.status[job.Name].Running = true
+	s.mu.Unlock()
+
+	start := time.Now()
+
+	err := func() (err error) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				err = fmt.Errorf("%s", rec)
+			}
+		}()
+		return job.Run(ctx)
+	}()
+
+	duration := time.Since(start)
+
+	s.mu.Lock()
+	status := s.status[job.Name]
+	status.Running = false
+	status.LastRun = &start
+	status.LastDuration = duration.String()
+	status.Runs++
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+		status.Failures++
+	}
+	s.mu.Unlock()
+
+	properties := map[string]string{
+		"job":      job.Name,
+		"duration": duration.String(),
+	}
+	if err != nil {
+		s.logger.PrintError(err, properties)
+		return
+	}
+	s.logger.PrintInfo("job completed", properties)
+}
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS copies;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS copies
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    book_id    bigint                      NOT NULL REFERENCES books ON DELETE CASCADE,
+    barcode    text UNIQUE                 NOT NULL,
+    condition  text                        NOT NULL,
+    location   text                        NOT NULL,
+    status     text                        NOT NULL DEFAULT 'available',
+    version    integer                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS copies_book_id_idx ON copies (book_id);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS loans
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    copy_id     bigint                      NOT NULL REFERENCES copies ON DELETE CASCADE,
+    user_id     bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    due_at      timestamp(0) with time zone NOT NULL,
+    returned_at timestamp(0) with time zone,
+    renewals    integer                     NOT NULL DEFAULT 0,
+    version     integer                     NOT NULL DEFAULT 1
+);
+
+-- A copy can only be lent to one patron at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS loans_active_copy_id_idx ON loans (copy_id) WHERE returned_at IS NULL;
+CREATE INDEX IF NOT EXISTS loans_user_id_idx ON loans (user_id);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS holds
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    book_id    bigint                      NOT NULL REFERENCES books ON DELETE CASCADE,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    status     text                        NOT NULL DEFAULT 'waiting',
+    copy_id    bigint REFERENCES copies ON DELETE SET NULL,
+    expires_at timestamp(0) with time zone,
+    version    integer                     NOT NULL DEFAULT 1
+);
+
+-- A patron can only queue once for the same book.
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_user_book_idx ON holds (user_id, book_id) WHERE status IN ('waiting', 'ready');
+CREATE INDEX IF NOT EXISTS holds_book_id_status_idx ON holds (book_id, status, id);
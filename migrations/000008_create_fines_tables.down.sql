@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'fines:write';
+DROP TABLE IF EXISTS fines;
+DROP TABLE IF EXISTS fine_rules;
+ALTER TABLE copies DROP COLUMN IF EXISTS item_type;
@@ -0,0 +1,38 @@
+ALTER TABLE copies
+    ADD COLUMN IF NOT EXISTS item_type text NOT NULL DEFAULT 'book';
+
+-- Overdue fines accrue per started day at daily_rate, up to max_amount per loan. Amounts are
+-- in cents.
+CREATE TABLE IF NOT EXISTS fine_rules
+(
+    item_type  text PRIMARY KEY,
+    daily_rate integer NOT NULL,
+    max_amount integer NOT NULL
+);
+
+INSERT INTO fine_rules (item_type, daily_rate, max_amount)
+VALUES
+    ('book', 25, 1000),
+    ('audiobook', 25, 1000),
+    ('magazine', 10, 300),
+    ('dvd', 100, 2000);
+
+-- The fines ledger. Charges are positive, payments and waivers are negative, so a user's
+-- balance is the sum of their entries.
+CREATE TABLE IF NOT EXISTS fines
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id     bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    loan_id     bigint REFERENCES loans ON DELETE SET NULL,
+    charge_id   bigint REFERENCES fines ON DELETE CASCADE,
+    kind        text                        NOT NULL,
+    amount      integer                     NOT NULL,
+    note        text                        NOT NULL DEFAULT '',
+    recorded_by bigint REFERENCES users ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS fines_user_id_idx ON fines (user_id);
+
+INSERT INTO permissions (code)
+VALUES ('fines:write');
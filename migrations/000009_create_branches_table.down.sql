@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS transfers;
+DROP INDEX IF EXISTS holds_active_user_book_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_user_book_idx ON holds (user_id, book_id) WHERE status IN ('waiting', 'ready');
+ALTER TABLE holds DROP COLUMN IF EXISTS pickup_branch_id;
+ALTER TABLE users DROP COLUMN IF EXISTS branch_id;
+ALTER TABLE copies DROP COLUMN IF EXISTS branch_id;
+DROP TABLE IF EXISTS branches;
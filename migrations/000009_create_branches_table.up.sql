@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS branches
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name       text UNIQUE                 NOT NULL,
+    address    text                        NOT NULL DEFAULT '',
+    version    integer                     NOT NULL DEFAULT 1
+);
+
+ALTER TABLE copies
+    ADD COLUMN IF NOT EXISTS branch_id bigint REFERENCES branches ON DELETE SET NULL;
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS branch_id bigint REFERENCES branches ON DELETE SET NULL;
+ALTER TABLE holds
+    ADD COLUMN IF NOT EXISTS pickup_branch_id bigint REFERENCES branches ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS copies_branch_id_idx ON copies (branch_id);
+
+-- Holds travelling between branches are still active.
+DROP INDEX IF EXISTS holds_active_user_book_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_user_book_idx ON holds (user_id, book_id) WHERE status IN ('waiting', 'in_transit', 'ready');
+
+CREATE TABLE IF NOT EXISTS transfers
+(
+    id             bigserial PRIMARY KEY,
+    created_at     timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    copy_id        bigint                      NOT NULL REFERENCES copies ON DELETE CASCADE,
+    from_branch_id bigint REFERENCES branches ON DELETE SET NULL,
+    to_branch_id   bigint                      NOT NULL REFERENCES branches ON DELETE CASCADE,
+    hold_id        bigint REFERENCES holds ON DELETE SET NULL,
+    status         text                        NOT NULL DEFAULT 'requested',
+    version        integer                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS transfers_status_idx ON transfers (status);
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'system:admin';
+DROP INDEX IF EXISTS tokens_expiry_idx;
+ALTER TABLE loans
+    DROP COLUMN IF EXISTS overdue_notice_sent_at,
+    DROP COLUMN IF EXISTS reminder_sent_at;
@@ -0,0 +1,8 @@
+ALTER TABLE loans
+    ADD COLUMN IF NOT EXISTS reminder_sent_at       timestamp(0) with time zone,
+    ADD COLUMN IF NOT EXISTS overdue_notice_sent_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_expiry_idx ON tokens (expiry);
+
+INSERT INTO permissions (code)
+VALUES ('system:admin');
@@ -0,0 +1,18 @@
+-- The email outbox. Messages are rendered when they are queued and delivered by a background
+-- job, which retries with exponential backoff until a message is sent or dead.
+CREATE TABLE IF NOT EXISTS emails
+(
+    id              bigserial PRIMARY KEY,
+    created_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    recipient       text                        NOT NULL,
+    subject         text                        NOT NULL,
+    plain_body      text                        NOT NULL,
+    html_body       text                        NOT NULL,
+    status          text                        NOT NULL DEFAULT 'pending',
+    attempts        integer                     NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_error      text                        NOT NULL DEFAULT '',
+    sent_at         timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS emails_pending_idx ON emails (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,12 @@
+-- Request counts for the Postgres rate limiter, one row per client and window. The counts are
+-- only useful for a couple of windows, so the table is unlogged.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits
+(
+    key          text                        NOT NULL,
+    window_start timestamp with time zone    NOT NULL,
+    count        integer                     NOT NULL,
+    expires_at   timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_expires_at_idx ON rate_limits (expires_at);
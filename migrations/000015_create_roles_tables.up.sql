@@ -0,0 +1,32 @@
+-- Roles bundle permissions, so that staff can be given a set of them at once. A user's
+-- permissions are the ones granted to them directly plus those of their roles.
+CREATE TABLE IF NOT EXISTS roles
+(
+    id   bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE
+);
+CREATE TABLE IF NOT EXISTS roles_permissions
+(
+    role_id       bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+CREATE TABLE IF NOT EXISTS users_roles
+(
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (code)
+VALUES
+    ('patron'),
+    ('librarian'),
+    ('admin');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE (roles.code = 'patron' AND permissions.code IN ('books:read'))
+   OR (roles.code = 'librarian' AND permissions.code IN ('books:read', 'books:write', 'fines:write'))
+   OR (roles.code = 'admin');
@@ -0,0 +1,3 @@
+-- An address the user has asked to change to, which becomes their email once they confirm it.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS pending_email citext;
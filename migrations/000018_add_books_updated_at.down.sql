@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS copies_touch_book ON copies;
+DROP FUNCTION IF EXISTS books_touch_for_copy();
+ALTER TABLE books
+    DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,23 @@
+-- When the book, or the availability of its copies, last changed. It is what GET
+-- /v1/books/:id reports as Last-Modified.
+ALTER TABLE books
+    ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+CREATE OR REPLACE FUNCTION books_touch_for_copy() RETURNS trigger AS
+$$
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        UPDATE books SET updated_at = NOW() WHERE id = OLD.book_id;
+    END IF;
+    IF TG_OP <> 'DELETE' AND (TG_OP = 'INSERT' OR NEW.book_id <> OLD.book_id) THEN
+        UPDATE books SET updated_at = NOW() WHERE id = NEW.book_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER copies_touch_book
+    AFTER INSERT OR DELETE OR UPDATE OF status, book_id
+    ON copies
+    FOR EACH ROW
+EXECUTE FUNCTION books_touch_for_copy();
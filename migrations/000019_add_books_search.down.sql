@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS books_search_idx;
+DROP TRIGGER IF EXISTS books_search_update ON books;
+DROP FUNCTION IF EXISTS books_search_update();
+DROP FUNCTION IF EXISTS books_search_vector(regconfig, text, text, text[]);
+ALTER TABLE books
+    DROP COLUMN IF EXISTS search,
+    DROP COLUMN IF EXISTS language;
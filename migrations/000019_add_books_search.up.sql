@@ -0,0 +1,40 @@
+-- The text search configuration a book's title, author and genres are stemmed with.
+ALTER TABLE books
+    ADD COLUMN IF NOT EXISTS language regconfig NOT NULL DEFAULT 'simple';
+
+-- books_search_vector weights matches in the title over the author, and the author over the
+-- genres. Every word is indexed both stemmed for the book's language and as written, so
+-- searches parsed with 'simple' find books in any language.
+CREATE OR REPLACE FUNCTION books_search_vector(language regconfig, title text, author text, genres text[])
+    RETURNS tsvector AS
+$$
+SELECT setweight(to_tsvector(language, title) || to_tsvector('simple', title), 'A') ||
+       setweight(to_tsvector(language, author) || to_tsvector('simple', author), 'B') ||
+       setweight(to_tsvector(language, array_to_string(genres, ' ')) ||
+                 to_tsvector('simple', array_to_string(genres, ' ')), 'C')
+$$ LANGUAGE sql STABLE;
+
+ALTER TABLE books
+    ADD COLUMN IF NOT EXISTS search tsvector;
+
+UPDATE books
+SET search = books_search_vector(language, title, author, genres);
+
+ALTER TABLE books
+    ALTER COLUMN search SET NOT NULL;
+
+CREATE OR REPLACE FUNCTION books_search_update() RETURNS trigger AS
+$$
+BEGIN
+    NEW.search := books_search_vector(NEW.language, NEW.title, NEW.author, NEW.genres);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_search_update
+    BEFORE INSERT OR UPDATE OF title, author, genres, language
+    ON books
+    FOR EACH ROW
+EXECUTE FUNCTION books_search_update();
+
+CREATE INDEX IF NOT EXISTS books_search_idx ON books USING GIN (search);
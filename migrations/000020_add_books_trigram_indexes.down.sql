@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS books_author_trgm_idx;
+DROP INDEX IF EXISTS books_title_trgm_idx;
+DROP EXTENSION IF EXISTS pg_trgm;